@@ -0,0 +1,72 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"regexp"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// DropEventRule drops the events whose field matches a regular expression.
+type DropEventRule struct {
+	// Field of the event, e.g. json.path.
+	Field string `json:"field"`
+
+	// Pattern is a regular expression in Go syntax.
+	Pattern string `json:"pattern"`
+}
+
+// LineFilters drop the lines of a log source nobody searches, e.g. health checks.
+type LineFilters struct {
+	// IncludeLines keeps only the lines matching one of the regular expressions.
+	// +optional
+	IncludeLines []string `json:"includeLines,omitempty"`
+
+	// ExcludeLines drops the lines matching one of the regular expressions.
+	// +optional
+	ExcludeLines []string `json:"excludeLines,omitempty"`
+
+	// DropEvents drops the events whose field matches, after the lines are decoded.
+	// +optional
+	DropEvents []DropEventRule `json:"dropEvents,omitempty"`
+}
+
+// ValidateLineFilters checks the regular expressions of the filters.
+func ValidateLineFilters(filters LineFilters, path *field.Path) field.ErrorList {
+	errs := field.ErrorList{}
+	for i, pattern := range filters.IncludeLines {
+		if _, err := regexp.Compile(pattern); err != nil {
+			errs = append(errs, field.Invalid(path.Child("includeLines").Index(i), pattern, err.Error()))
+		}
+	}
+	for i, pattern := range filters.ExcludeLines {
+		if _, err := regexp.Compile(pattern); err != nil {
+			errs = append(errs, field.Invalid(path.Child("excludeLines").Index(i), pattern, err.Error()))
+		}
+	}
+	for i, rule := range filters.DropEvents {
+		p := path.Child("dropEvents").Index(i)
+		if rule.Field == "" {
+			errs = append(errs, field.Required(p.Child("field"), ""))
+		}
+		if _, err := regexp.Compile(rule.Pattern); err != nil {
+			errs = append(errs, field.Invalid(p.Child("pattern"), rule.Pattern, err.Error()))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// LogPolicySpec defines the namespace wide log collection defaults.
+// Values declared by a container through k8s_logs_* env vars take precedence.
+type LogPolicySpec struct {
+	// IndexPrefix lists extra env var prefixes, next to the ones from
+	// LOGGING_INDEX_PREFIX, that declare log sources in this namespace.
+	// +optional
+	IndexPrefix []string `json:"indexPrefix,omitempty"`
+
+	// Index is the default index and topic name of log sources that do not declare one.
+	// +optional
+	Index string `json:"index,omitempty"`
+
+	// Tags are added to every log source, container tags with the same key win.
+	// +optional
+	Tags map[string]string `json:"tags,omitempty"`
+
+	// Format is the default log format, one of none|json|csv|nginx|apache2|apache_error|regexp.
+	// +optional
+	Format string `json:"format,omitempty"`
+
+	// Multiline is the default multiline preset, e.g. java.
+	// +optional
+	Multiline string `json:"multiline,omitempty"`
+
+	// Output enables collection for containers without any declaration,
+	// either "stdout" or the absolute path of a log file inside the container.
+	// +optional
+	Output string `json:"output,omitempty"`
+
+	// LogLimits are the default limits of the log sources of the namespace.
+	LogLimits `json:",inline"`
+
+	// Redact rules apply to every log source of the namespace, after the cluster ones.
+	// +optional
+	Redact []RedactionRule `json:"redact,omitempty"`
+
+	// Retention is the default retention of the indices of the WatchLogs of the namespace.
+	// +kubebuilder:validation:Pattern=`^[0-9]+[dh]$`
+	// +optional
+	Retention string `json:"retention,omitempty"`
+}
+
+// LogPolicyStatus defines the observed state of LogPolicy
+type LogPolicyStatus struct {
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+
+// LogPolicy is the Schema for the logpolicies API
+type LogPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   LogPolicySpec   `json:"spec,omitempty"`
+	Status LogPolicyStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// LogPolicyList contains a list of LogPolicy
+type LogPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []LogPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&LogPolicy{}, &LogPolicyList{})
+}
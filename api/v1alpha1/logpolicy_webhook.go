@@ -0,0 +1,78 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// log is for logging in this package.
+var logpolicylog = logf.Log.WithName("logpolicy-resource")
+
+func (r *LogPolicy) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/validate-crd-k8s-deeproute-cn-v1alpha1-logpolicy,mutating=false,failurePolicy=fail,sideEffects=None,groups=crd.k8s.deeproute.cn,resources=logpolicies,verbs=create;update,versions=v1alpha1,name=vlogpolicy.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Validator = &LogPolicy{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *LogPolicy) ValidateCreate() error {
+	logpolicylog.Info("validate create", "name", r.Name)
+	return r.validate()
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *LogPolicy) ValidateUpdate(old runtime.Object) error {
+	logpolicylog.Info("validate update", "name", r.Name)
+	return r.validate()
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *LogPolicy) ValidateDelete() error {
+	return nil
+}
+
+func (r *LogPolicy) validate() error {
+	spec := field.NewPath("spec")
+	errs := field.ErrorList{}
+	for _, text := range [][2]string{
+		{"index", r.Spec.Index},
+		{"format", r.Spec.Format},
+		{"multiline", r.Spec.Multiline},
+		{"output", r.Spec.Output},
+	} {
+		errs = append(errs, ValidateText(text[1], spec.Child(text[0]))...)
+	}
+	for i, prefix := range r.Spec.IndexPrefix {
+		errs = append(errs, ValidateText(prefix, spec.Child("indexPrefix").Index(i))...)
+	}
+	errs = append(errs, ValidateOptions(r.Spec.Tags, spec.Child("tags"))...)
+	errs = append(errs, ValidateRedactionRules(r.Spec.Redact, spec.Child("redact"))...)
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("LogPolicy").GroupKind(), r.Name, errs)
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"regexp"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+const (
+	RedactReplace = "Replace"
+	RedactDrop    = "Drop"
+	RedactHash    = "Hash"
+)
+
+// RedactionRule scrubs sensitive data from the events of a log source before they leave the node.
+type RedactionRule struct {
+	// Name identifies the rule in errors.
+	Name string `json:"name"`
+
+	// Field is the event field the rule applies to, by default the line: message,
+	// or json.log for the json sources whose lines are decoded under json.
+	// +optional
+	Field string `json:"field,omitempty"`
+
+	// Action is Replace to replace the matches of Pattern, Drop to remove the
+	// field or Hash to replace the field by its sha256.
+	// +kubebuilder:validation:Enum=Replace;Drop;Hash
+	Action string `json:"action"`
+
+	// Pattern is the regular expression replaced, in Go syntax, required by Replace.
+	// +optional
+	Pattern string `json:"pattern,omitempty"`
+
+	// Replacement of the matches of Pattern, $1 expands to the first group.
+	// +optional
+	Replacement string `json:"replacement,omitempty"`
+}
+
+// ValidateRedactionRules checks the rules the way the agents compile them.
+func ValidateRedactionRules(rules []RedactionRule, path *field.Path) field.ErrorList {
+	errs := field.ErrorList{}
+	names := make(map[string]bool)
+	for i, rule := range rules {
+		p := path.Index(i)
+		if rule.Name == "" {
+			errs = append(errs, field.Required(p.Child("name"), ""))
+		} else if names[rule.Name] {
+			errs = append(errs, field.Duplicate(p.Child("name"), rule.Name))
+		}
+		names[rule.Name] = true
+		switch rule.Action {
+		case RedactReplace:
+			if rule.Pattern == "" {
+				errs = append(errs, field.Required(p.Child("pattern"), "Replace needs a pattern"))
+			} else if _, err := regexp.Compile(rule.Pattern); err != nil {
+				errs = append(errs, field.Invalid(p.Child("pattern"), rule.Pattern, err.Error()))
+			}
+		case RedactDrop, RedactHash:
+			if rule.Pattern != "" {
+				errs = append(errs, field.Forbidden(p.Child("pattern"), rule.Action+" applies to the whole field"))
+			}
+		default:
+			errs = append(errs, field.NotSupported(p.Child("action"), rule.Action, []string{RedactReplace, RedactDrop, RedactHash}))
+		}
+	}
+	return errs
+}
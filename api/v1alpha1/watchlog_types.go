@@ -28,18 +28,195 @@ type WatchLogSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 
-	// Foo is an example field of WatchLog. Edit watchlog_types.go to remove/update
-	Foo string `json:"foo,omitempty"`
+	// Selector picks the pods of the WatchLog namespace whose logs are collected.
+	Selector *metav1.LabelSelector `json:"selector"`
+
+	// Sources are the log sources of the selected pods, a source declared by the
+	// container through k8s_logs_* env vars with the same name wins.
+	// +optional
+	Sources []LogSource `json:"sources,omitempty"`
+
+	// Retention is how long the indices of the selected pods are kept, e.g. 7d or
+	// 36h, when the controller manages the elasticsearch lifecycle policies. The
+	// retention of the namespace LogPolicy applies when empty. The indices become
+	// data streams, the agents write them with create operations.
+	// +kubebuilder:validation:Pattern=`^[0-9]+[dh]$`
+	// +optional
+	Retention string `json:"retention,omitempty"`
+}
+
+// LogSource is the WatchLog equivalent of the k8s_logs_<name>_* env vars of a container.
+type LogSource struct {
+	// Name of the log source, used as the default index and topic.
+	Name string `json:"name"`
+
+	// Container limits the source to one container of the pod, all containers when empty.
+	// +optional
+	Container string `json:"container,omitempty"`
+
+	// Output is either "stdout" or the absolute path of a log file inside the container.
+	Output string `json:"output"`
+
+	// +optional
+	Index string `json:"index,omitempty"`
+
+	// Format is one of none|json|csv|nginx|apache2|apache_error|regexp.
+	// +optional
+	Format string `json:"format,omitempty"`
+
+	// Multiline is a multiline preset, e.g. java.
+	// +optional
+	Multiline string `json:"multiline,omitempty"`
+
+	// Rotation is how the log file of a path output is rotated, rotated files are
+	// collected or skipped accordingly.
+	// +kubebuilder:validation:Enum=rename;copytruncate;dated
+	// +optional
+	Rotation string `json:"rotation,omitempty"`
+
+	// +optional
+	Tags map[string]string `json:"tags,omitempty"`
+
+	// Config holds extra filebeat input options, e.g. close_eof.
+	// +optional
+	Config map[string]string `json:"config,omitempty"`
+
+	LogLimits `json:",inline"`
+
+	LineFilters `json:",inline"`
+
+	// Redact rules apply after the namespace and cluster ones.
+	// +optional
+	Redact []RedactionRule `json:"redact,omitempty"`
+}
+
+// LogLimits guards the backends against a noisy log source, the ceilings of the
+// LOGGING_MAX_* env vars of the agents apply on top.
+type LogLimits struct {
+	// RateLimit caps the events of the source per pod, e.g. 100/s or 6000/m.
+	// +kubebuilder:validation:Pattern=`^[0-9]+/[smh]$`
+	// +optional
+	RateLimit string `json:"rateLimit,omitempty"`
+
+	// RateLimitBytes caps the bytes per second of the source per pod. Filebeat only
+	// limits events, so it is enforced as a limit of events counted as MaxBytes each:
+	// a worst case bound, sources of shorter lines ship fewer bytes. It needs MaxBytes,
+	// set here, in the LogPolicy or by the LOGGING_MAX_BYTES ceiling.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	RateLimitBytes int64 `json:"rateLimitBytes,omitempty"`
+
+	// MaxBytes truncates the lines, or multiline events, longer than this many bytes.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MaxBytes int64 `json:"maxBytes,omitempty"`
 }
 
 // WatchLogStatus defines the observed state of WatchLog
 type WatchLogStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
+
+	// Nodes is the number of nodes reporting selected pods.
+	// +optional
+	Nodes int32 `json:"nodes,omitempty"`
+
+	// Matched is the number of running pods selected on all nodes.
+	// +optional
+	Matched int32 `json:"matched,omitempty"`
+
+	// Collected is the number of matched pods whose log sources are all rendered.
+	// +optional
+	Collected int32 `json:"collected,omitempty"`
+
+	// Failed is the number of matched pods with a log source that failed to render.
+	// +optional
+	Failed int32 `json:"failed,omitempty"`
+
+	// FailedPods lists some of the failed pods with their latest error.
+	// +optional
+	FailedPods []PodFailure `json:"failedPods,omitempty"`
+
+	// UnhealthyInputs lists the inputs of selected pods whose logs are not shipped in time.
+	// +optional
+	UnhealthyInputs []InputHealth `json:"unhealthyInputs,omitempty"`
+
+	// IndexCollisions lists the indices of selected pods other log sources of the
+	// cluster also write to.
+	// +optional
+	IndexCollisions []IndexCollision `json:"indexCollisions,omitempty"`
+
+	// ManagedIndices reports the elasticsearch index template and lifecycle policy
+	// of every index of the selected pods with a retention, when the controller
+	// manages them.
+	// +optional
+	ManagedIndices []ManagedIndex `json:"managedIndices,omitempty"`
+
+	// ManagedTopics reports the kafka topic of every log source of the selected pods,
+	// when the controller creates the missing ones.
+	// +optional
+	ManagedTopics []ManagedTopic `json:"managedTopics,omitempty"`
+}
+
+// ManagedIndex is the outcome of the last sync of the index template and lifecycle
+// policy of an index.
+type ManagedIndex struct {
+	Index string `json:"index"`
+	// Retention is the retention applied, the longest one of the WatchLogs sharing
+	// the index, empty when the index is kept forever.
+	// +optional
+	Retention string `json:"retention,omitempty"`
+	// Synced is false when the last sync failed.
+	Synced bool `json:"synced"`
+	// Unmanaged is true when the index already exists and is not a data stream,
+	// its lifecycle is left alone.
+	// +optional
+	Unmanaged bool `json:"unmanaged,omitempty"`
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// IndexCollision is an index written to by several log sources.
+type IndexCollision struct {
+	Index   string   `json:"index"`
+	Sources []string `json:"sources"`
+}
+
+// ManagedTopic is the outcome of the last check of a kafka topic.
+type ManagedTopic struct {
+	Topic string `json:"topic"`
+	// Ready is true once the topic exists.
+	Ready bool `json:"ready"`
+	// Message tells why the topic is missing, e.g. not allowed by the allowlist.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// InputHealth reports an input lagging behind or stalled on one node.
+type InputHealth struct {
+	Node      string `json:"node"`
+	Pod       string `json:"pod"`
+	Container string `json:"container"`
+	Source    string `json:"source"`
+
+	// Reason is Lagging when the unshipped bytes exceed the threshold, Stalled
+	// when no harvester made progress for too long.
+	// +kubebuilder:validation:Enum=Lagging;Stalled
+	Reason string `json:"reason"`
+
+	// LagBytes is the size of the tracked files minus the registry offsets.
+	LagBytes int64 `json:"lagBytes"`
+
+	// Since is when the input became unhealthy.
+	Since metav1.Time `json:"since"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Matched",type=integer,JSONPath=`.status.matched`
+//+kubebuilder:printcolumn:name="Collected",type=integer,JSONPath=`.status.collected`
+//+kubebuilder:printcolumn:name="Failed",type=integer,JSONPath=`.status.failed`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // WatchLog is the Schema for the watchlogs API
 type WatchLog struct {
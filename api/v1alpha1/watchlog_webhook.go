@@ -0,0 +1,119 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// log is for logging in this package.
+var watchloglog = logf.Log.WithName("watchlog-resource")
+
+func (r *WatchLog) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/validate-crd-k8s-deeproute-cn-v1alpha1-watchlog,mutating=false,failurePolicy=fail,sideEffects=None,groups=crd.k8s.deeproute.cn,resources=watchlogs,verbs=create;update,versions=v1alpha1,name=vwatchlog.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Validator = &WatchLog{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *WatchLog) ValidateCreate() error {
+	watchloglog.Info("validate create", "name", r.Name)
+	return r.validate()
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *WatchLog) ValidateUpdate(old runtime.Object) error {
+	watchloglog.Info("validate update", "name", r.Name)
+	return r.validate()
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *WatchLog) ValidateDelete() error {
+	return nil
+}
+
+func (r *WatchLog) validate() error {
+	errs := field.ErrorList{}
+	sources := field.NewPath("spec").Child("sources")
+	for i, source := range r.Spec.Sources {
+		p := sources.Index(i)
+		for _, text := range [][2]string{
+			{"name", source.Name},
+			{"container", source.Container},
+			{"output", source.Output},
+			{"index", source.Index},
+			{"format", source.Format},
+			{"multiline", source.Multiline},
+		} {
+			errs = append(errs, ValidateText(text[1], p.Child(text[0]))...)
+		}
+		errs = append(errs, ValidateOptions(source.Tags, p.Child("tags"))...)
+		errs = append(errs, ValidateOptions(source.Config, p.Child("config"))...)
+		errs = append(errs, ValidateRedactionRules(source.Redact, p.Child("redact"))...)
+		errs = append(errs, ValidateLineFilters(source.LineFilters, p)...)
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("WatchLog").GroupKind(), r.Name, errs)
+}
+
+// optionKey is a field or filebeat option name, dots separate the nested ones.
+var optionKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)*$`)
+
+// ValidateOptions checks tags and filebeat options, they are written into the
+// input files of the agents.
+func ValidateOptions(options map[string]string, path *field.Path) field.ErrorList {
+	errs := field.ErrorList{}
+	keys := make([]string, 0, len(options))
+	for key := range options {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := options[key]
+		if !optionKey.MatchString(key) {
+			errs = append(errs, field.Invalid(path, key, "keys are identifiers, optionally separated by dots"))
+			continue
+		}
+		errs = append(errs, ValidateText(value, path.Key(key))...)
+	}
+	return errs
+}
+
+// ValidateText rejects control characters, e.g. a newline, in a value written into
+// the input files of the agents.
+func ValidateText(value string, path *field.Path) field.ErrorList {
+	if strings.IndexFunc(value, unicode.IsControl) < 0 {
+		return nil
+	}
+	return field.ErrorList{field.Invalid(path, value, "control characters are not allowed")}
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// WatchLogNodeStatusSpec names the WatchLog and the node reported on.
+type WatchLogNodeStatusSpec struct {
+	WatchLog string `json:"watchLog"`
+	NodeName string `json:"nodeName"`
+}
+
+// WatchLogNodeStatusStatus is what the agent of a node collects for a WatchLog.
+type WatchLogNodeStatusStatus struct {
+	// Matched is the number of running pods of the node selected by the WatchLog.
+	Matched int32 `json:"matched"`
+
+	// Collected is the number of matched pods whose log sources are all rendered.
+	Collected int32 `json:"collected"`
+
+	// Failed is the number of matched pods with a log source that failed to render.
+	Failed int32 `json:"failed"`
+
+	// FailedPods lists some of the failed pods with their latest error.
+	// +optional
+	FailedPods []PodFailure `json:"failedPods,omitempty"`
+
+	// UnhealthyInputs lists the inputs of the node whose logs are not shipped in time.
+	// +optional
+	UnhealthyInputs []InputHealth `json:"unhealthyInputs,omitempty"`
+
+	// Indices lists the indices and topics the selected pods of the node ship to.
+	// +optional
+	Indices []IndexSource `json:"indices,omitempty"`
+
+	// LastUpdateTime is when the agent last reported, a stale report is ignored.
+	// +optional
+	LastUpdateTime metav1.Time `json:"lastUpdateTime,omitempty"`
+}
+
+// PodFailure is the latest render error of a pod.
+type PodFailure struct {
+	Node    string `json:"node"`
+	Pod     string `json:"pod"`
+	Message string `json:"message"`
+}
+
+// IndexSource is an index and the log source writing to it, named by its namespace
+// and declared index. Log sources of different namespaces or with different
+// declared indices sharing an index collide.
+type IndexSource struct {
+	Index  string `json:"index"`
+	Source string `json:"source"`
+	// Topic is the kafka topic of the log source.
+	// +optional
+	Topic string `json:"topic,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="WatchLog",type=string,JSONPath=`.spec.watchLog`
+//+kubebuilder:printcolumn:name="Node",type=string,JSONPath=`.spec.nodeName`
+//+kubebuilder:printcolumn:name="Matched",type=integer,JSONPath=`.status.matched`
+//+kubebuilder:printcolumn:name="Collected",type=integer,JSONPath=`.status.collected`
+//+kubebuilder:printcolumn:name="Failed",type=integer,JSONPath=`.status.failed`
+
+// WatchLogNodeStatus is the Schema for the watchlognodestatuses API, written by
+// the agent of a node and aggregated into the WatchLog status by the controller.
+type WatchLogNodeStatus struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   WatchLogNodeStatusSpec   `json:"spec,omitempty"`
+	Status WatchLogNodeStatusStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// WatchLogNodeStatusList contains a list of WatchLogNodeStatus
+type WatchLogNodeStatusList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []WatchLogNodeStatus `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&WatchLogNodeStatus{}, &WatchLogNodeStatusList{})
+}
@@ -22,18 +22,333 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *WatchLog) DeepCopyInto(out *WatchLog) {
+func (in *DropEventRule) DeepCopyInto(out *DropEventRule) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DropEventRule.
+func (in *DropEventRule) DeepCopy() *DropEventRule {
+	if in == nil {
+		return nil
+	}
+	out := new(DropEventRule)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IndexCollision) DeepCopyInto(out *IndexCollision) {
+	*out = *in
+	if in.Sources != nil {
+		in, out := &in.Sources, &out.Sources
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IndexCollision.
+func (in *IndexCollision) DeepCopy() *IndexCollision {
+	if in == nil {
+		return nil
+	}
+	out := new(IndexCollision)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IndexSource) DeepCopyInto(out *IndexSource) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IndexSource.
+func (in *IndexSource) DeepCopy() *IndexSource {
+	if in == nil {
+		return nil
+	}
+	out := new(IndexSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *InputHealth) DeepCopyInto(out *InputHealth) {
+	*out = *in
+	in.Since.DeepCopyInto(&out.Since)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InputHealth.
+func (in *InputHealth) DeepCopy() *InputHealth {
+	if in == nil {
+		return nil
+	}
+	out := new(InputHealth)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LineFilters) DeepCopyInto(out *LineFilters) {
+	*out = *in
+	if in.IncludeLines != nil {
+		in, out := &in.IncludeLines, &out.IncludeLines
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ExcludeLines != nil {
+		in, out := &in.ExcludeLines, &out.ExcludeLines
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.DropEvents != nil {
+		in, out := &in.DropEvents, &out.DropEvents
+		*out = make([]DropEventRule, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LineFilters.
+func (in *LineFilters) DeepCopy() *LineFilters {
+	if in == nil {
+		return nil
+	}
+	out := new(LineFilters)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LogLimits) DeepCopyInto(out *LogLimits) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LogLimits.
+func (in *LogLimits) DeepCopy() *LogLimits {
+	if in == nil {
+		return nil
+	}
+	out := new(LogLimits)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LogPolicy) DeepCopyInto(out *LogPolicy) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
+	in.Spec.DeepCopyInto(&out.Spec)
 	out.Status = in.Status
 }
 
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LogPolicy.
+func (in *LogPolicy) DeepCopy() *LogPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(LogPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *LogPolicy) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LogPolicyList) DeepCopyInto(out *LogPolicyList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]LogPolicy, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LogPolicyList.
+func (in *LogPolicyList) DeepCopy() *LogPolicyList {
+	if in == nil {
+		return nil
+	}
+	out := new(LogPolicyList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *LogPolicyList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LogPolicySpec) DeepCopyInto(out *LogPolicySpec) {
+	*out = *in
+	if in.IndexPrefix != nil {
+		in, out := &in.IndexPrefix, &out.IndexPrefix
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Tags != nil {
+		in, out := &in.Tags, &out.Tags
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	out.LogLimits = in.LogLimits
+	if in.Redact != nil {
+		in, out := &in.Redact, &out.Redact
+		*out = make([]RedactionRule, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LogPolicySpec.
+func (in *LogPolicySpec) DeepCopy() *LogPolicySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(LogPolicySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LogPolicyStatus) DeepCopyInto(out *LogPolicyStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LogPolicyStatus.
+func (in *LogPolicyStatus) DeepCopy() *LogPolicyStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(LogPolicyStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LogSource) DeepCopyInto(out *LogSource) {
+	*out = *in
+	if in.Tags != nil {
+		in, out := &in.Tags, &out.Tags
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Config != nil {
+		in, out := &in.Config, &out.Config
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	out.LogLimits = in.LogLimits
+	in.LineFilters.DeepCopyInto(&out.LineFilters)
+	if in.Redact != nil {
+		in, out := &in.Redact, &out.Redact
+		*out = make([]RedactionRule, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LogSource.
+func (in *LogSource) DeepCopy() *LogSource {
+	if in == nil {
+		return nil
+	}
+	out := new(LogSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ManagedIndex) DeepCopyInto(out *ManagedIndex) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ManagedIndex.
+func (in *ManagedIndex) DeepCopy() *ManagedIndex {
+	if in == nil {
+		return nil
+	}
+	out := new(ManagedIndex)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ManagedTopic) DeepCopyInto(out *ManagedTopic) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ManagedTopic.
+func (in *ManagedTopic) DeepCopy() *ManagedTopic {
+	if in == nil {
+		return nil
+	}
+	out := new(ManagedTopic)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PodFailure) DeepCopyInto(out *PodFailure) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PodFailure.
+func (in *PodFailure) DeepCopy() *PodFailure {
+	if in == nil {
+		return nil
+	}
+	out := new(PodFailure)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RedactionRule) DeepCopyInto(out *RedactionRule) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RedactionRule.
+func (in *RedactionRule) DeepCopy() *RedactionRule {
+	if in == nil {
+		return nil
+	}
+	out := new(RedactionRule)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WatchLog) DeepCopyInto(out *WatchLog) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WatchLog.
 func (in *WatchLog) DeepCopy() *WatchLog {
 	if in == nil {
@@ -84,9 +399,128 @@ func (in *WatchLogList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WatchLogNodeStatus) DeepCopyInto(out *WatchLogNodeStatus) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WatchLogNodeStatus.
+func (in *WatchLogNodeStatus) DeepCopy() *WatchLogNodeStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(WatchLogNodeStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *WatchLogNodeStatus) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WatchLogNodeStatusList) DeepCopyInto(out *WatchLogNodeStatusList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]WatchLogNodeStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WatchLogNodeStatusList.
+func (in *WatchLogNodeStatusList) DeepCopy() *WatchLogNodeStatusList {
+	if in == nil {
+		return nil
+	}
+	out := new(WatchLogNodeStatusList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *WatchLogNodeStatusList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WatchLogNodeStatusSpec) DeepCopyInto(out *WatchLogNodeStatusSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WatchLogNodeStatusSpec.
+func (in *WatchLogNodeStatusSpec) DeepCopy() *WatchLogNodeStatusSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(WatchLogNodeStatusSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WatchLogNodeStatusStatus) DeepCopyInto(out *WatchLogNodeStatusStatus) {
+	*out = *in
+	if in.FailedPods != nil {
+		in, out := &in.FailedPods, &out.FailedPods
+		*out = make([]PodFailure, len(*in))
+		copy(*out, *in)
+	}
+	if in.UnhealthyInputs != nil {
+		in, out := &in.UnhealthyInputs, &out.UnhealthyInputs
+		*out = make([]InputHealth, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Indices != nil {
+		in, out := &in.Indices, &out.Indices
+		*out = make([]IndexSource, len(*in))
+		copy(*out, *in)
+	}
+	in.LastUpdateTime.DeepCopyInto(&out.LastUpdateTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WatchLogNodeStatusStatus.
+func (in *WatchLogNodeStatusStatus) DeepCopy() *WatchLogNodeStatusStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(WatchLogNodeStatusStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *WatchLogSpec) DeepCopyInto(out *WatchLogSpec) {
 	*out = *in
+	if in.Selector != nil {
+		in, out := &in.Selector, &out.Selector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Sources != nil {
+		in, out := &in.Sources, &out.Sources
+		*out = make([]LogSource, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WatchLogSpec.
@@ -102,6 +536,35 @@ func (in *WatchLogSpec) DeepCopy() *WatchLogSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *WatchLogStatus) DeepCopyInto(out *WatchLogStatus) {
 	*out = *in
+	if in.FailedPods != nil {
+		in, out := &in.FailedPods, &out.FailedPods
+		*out = make([]PodFailure, len(*in))
+		copy(*out, *in)
+	}
+	if in.UnhealthyInputs != nil {
+		in, out := &in.UnhealthyInputs, &out.UnhealthyInputs
+		*out = make([]InputHealth, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.IndexCollisions != nil {
+		in, out := &in.IndexCollisions, &out.IndexCollisions
+		*out = make([]IndexCollision, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.ManagedIndices != nil {
+		in, out := &in.ManagedIndices, &out.ManagedIndices
+		*out = make([]ManagedIndex, len(*in))
+		copy(*out, *in)
+	}
+	if in.ManagedTopics != nil {
+		in, out := &in.ManagedTopics, &out.ManagedTopics
+		*out = make([]ManagedTopic, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WatchLogStatus.
@@ -0,0 +1,330 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// kubectl-logs_helper shows how the pods of a namespace or workload are collected. kubectl
+// maps the underscore of the binary name to the dash of the command, a dash would make
+// it a logs subcommand, which kubectl does not let plugins add to its builtin logs:
+//
+//	kubectl logs-helper [-n namespace] [pod/NAME | deployment/NAME | statefulset/NAME | daemonset/NAME | job/NAME]
+//
+// The sources are read from the /status/pods endpoint of the agent of each node
+// through the API server pod proxy, which needs get on pods/proxy in the agent namespace.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	crdk8sv1alpha1 "github.com/cccfs/kube-log-helper/api/v1alpha1"
+	"github.com/cccfs/kube-log-helper/controllers"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const eventSource = "kube-log-helper"
+
+var scheme = runtime.NewScheme()
+
+func init() {
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(crdk8sv1alpha1.AddToScheme(scheme))
+}
+
+type options struct {
+	namespace      string
+	agentNamespace string
+	agentSelector  string
+	agentPort      int
+	target         string
+}
+
+func main() {
+	opts := &options{}
+	flag.StringVar(&opts.namespace, "n", "", "Namespace of the pods, the current context namespace by default.")
+	flag.StringVar(&opts.agentNamespace, "agent-namespace", "kube-log-helper-system", "Namespace of the node agents.")
+	flag.StringVar(&opts.agentSelector, "agent-selector", "control-plane=agent", "Label selector of the node agent pods.")
+	flag.IntVar(&opts.agentPort, "agent-port", 8081, "Port of the status endpoints of the node agents.")
+	flag.Parse()
+	if flag.NArg() > 1 {
+		fmt.Fprintln(os.Stderr, "usage: kubectl logs-helper [-n namespace] [TYPE/NAME]")
+		os.Exit(2)
+	}
+	opts.target = flag.Arg(0)
+
+	if err := run(context.Background(), opts); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, opts *options) error {
+	loader := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		clientcmd.NewDefaultClientConfigLoadingRules(), &clientcmd.ConfigOverrides{})
+	if opts.namespace == "" {
+		namespace, _, err := loader.Namespace()
+		if err != nil {
+			return err
+		}
+		opts.namespace = namespace
+	}
+	config, err := loader.ClientConfig()
+	if err != nil {
+		return err
+	}
+	c, err := client.New(config, client.Options{Scheme: scheme})
+	if err != nil {
+		return err
+	}
+
+	pods, err := selectPods(ctx, c, opts.namespace, opts.target)
+	if err != nil {
+		return err
+	}
+	if len(pods) == 0 {
+		fmt.Printf("No pods found in %s namespace.\n", opts.namespace)
+		return nil
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+	agents, err := agentPods(ctx, c, opts)
+	if err != nil {
+		return err
+	}
+
+	watchLogs := &crdk8sv1alpha1.WatchLogList{}
+	if err := c.List(ctx, watchLogs, client.InNamespace(opts.namespace)); err != nil {
+		return err
+	}
+
+	// sources of the pods by node, fetched once per agent
+	reported := make(map[string]map[string][]controllers.SourceStatus)
+	unreachable := make(map[string]error)
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "POD\tCONTAINER\tSOURCE\tINDEX\tTOPIC\tFORMAT\tMULTILINE\tNODE\tAGENT\tSTATUS")
+	for i := range pods {
+		pod := &pods[i]
+		node, agent := pod.Spec.NodeName, agents[pod.Spec.NodeName]
+		status := ""
+		switch {
+		case node == "":
+			status = "Not scheduled"
+		case agent == "":
+			status = "No agent on node"
+		default:
+			if _, ok := reported[node]; !ok && unreachable[node] == nil {
+				reported[node], unreachable[node] = agentSources(ctx, clientset, opts, agent)
+			}
+			if err := unreachable[node]; err != nil {
+				status = fmt.Sprintf("Agent unreachable: %v", err)
+			}
+		}
+		sources := reported[node][pod.Name]
+		if status == "" && len(sources) == 0 {
+			status = "Not collected"
+		}
+		if status != "" {
+			fmt.Fprintf(w, "%s\t-\t-\t-\t-\t-\t-\t%s\t%s\t%s\n", pod.Name, orDash(node), orDash(agent), status)
+			continue
+		}
+		for _, source := range sources {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				pod.Name, source.Container, source.Source, orDash(source.Index), orDash(source.Topic),
+				orDash(source.Format), orDash(source.Multiline), node, agent,
+				sourceStatus(pod, source, watchLogs.Items))
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return printLatestEvents(ctx, c, pods)
+}
+
+// selectPods returns the pods of the namespace, or of the pod or workload named by target.
+func selectPods(ctx context.Context, c client.Client, namespace, target string) ([]corev1.Pod, error) {
+	if target == "" {
+		pods := &corev1.PodList{}
+		if err := c.List(ctx, pods, client.InNamespace(namespace)); err != nil {
+			return nil, err
+		}
+		return pods.Items, nil
+	}
+	parts := strings.SplitN(target, "/", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("expected TYPE/NAME, got %q", target)
+	}
+	key := types.NamespacedName{Namespace: namespace, Name: parts[1]}
+
+	var selector *metav1.LabelSelector
+	switch strings.ToLower(parts[0]) {
+	case "pod", "pods", "po":
+		pod := &corev1.Pod{}
+		if err := c.Get(ctx, key, pod); err != nil {
+			return nil, err
+		}
+		return []corev1.Pod{*pod}, nil
+	case "deployment", "deployments", "deploy":
+		obj := &appsv1.Deployment{}
+		if err := c.Get(ctx, key, obj); err != nil {
+			return nil, err
+		}
+		selector = obj.Spec.Selector
+	case "statefulset", "statefulsets", "sts":
+		obj := &appsv1.StatefulSet{}
+		if err := c.Get(ctx, key, obj); err != nil {
+			return nil, err
+		}
+		selector = obj.Spec.Selector
+	case "daemonset", "daemonsets", "ds":
+		obj := &appsv1.DaemonSet{}
+		if err := c.Get(ctx, key, obj); err != nil {
+			return nil, err
+		}
+		selector = obj.Spec.Selector
+	case "job", "jobs":
+		obj := &batchv1.Job{}
+		if err := c.Get(ctx, key, obj); err != nil {
+			return nil, err
+		}
+		selector = obj.Spec.Selector
+	default:
+		return nil, fmt.Errorf("unsupported type %q", parts[0])
+	}
+
+	s, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	pods := &corev1.PodList{}
+	if err := c.List(ctx, pods, client.InNamespace(namespace), client.MatchingLabelsSelector{Selector: s}); err != nil {
+		return nil, err
+	}
+	return pods.Items, nil
+}
+
+// agentPods maps node names to the agent pod running there.
+func agentPods(ctx context.Context, c client.Client, opts *options) (map[string]string, error) {
+	selector, err := labels.Parse(opts.agentSelector)
+	if err != nil {
+		return nil, err
+	}
+	pods := &corev1.PodList{}
+	if err := c.List(ctx, pods, client.InNamespace(opts.agentNamespace), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, err
+	}
+	agents := make(map[string]string)
+	for _, pod := range pods.Items {
+		if pod.Status.Phase == corev1.PodRunning {
+			agents[pod.Spec.NodeName] = pod.Name
+		}
+	}
+	return agents, nil
+}
+
+// agentSources reads the sources of the pods of the namespace from an agent, keyed by pod name.
+func agentSources(ctx context.Context, clientset kubernetes.Interface, opts *options, agent string) (map[string][]controllers.SourceStatus, error) {
+	data, err := clientset.CoreV1().Pods(opts.agentNamespace).
+		ProxyGet("http", agent, strconv.Itoa(opts.agentPort), "/status/pods", map[string]string{"namespace": opts.namespace}).
+		DoRaw(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pods := make([]controllers.PodSources, 0)
+	if err := json.Unmarshal(data, &pods); err != nil {
+		return nil, fmt.Errorf("unexpected /status/pods response of %s: %v", agent, err)
+	}
+	sources := make(map[string][]controllers.SourceStatus, len(pods))
+	for _, pod := range pods {
+		sources[pod.Pod] = pod.Sources
+	}
+	return sources, nil
+}
+
+func sourceStatus(pod *corev1.Pod, source controllers.SourceStatus, watchLogs []crdk8sv1alpha1.WatchLog) string {
+	switch {
+	case source.Error != "":
+		return "Error: " + source.Error
+	case source.Rejected != "":
+		return fmt.Sprintf("Rejected by %q", source.Rejected)
+	}
+	for _, watchLog := range watchLogs {
+		for _, input := range watchLog.Status.UnhealthyInputs {
+			if input.Pod == pod.Name && input.Container == source.Container && input.Source == source.Source {
+				return fmt.Sprintf("%s since %s, %d bytes behind", input.Reason, input.Since.Format(time.RFC3339), input.LagBytes)
+			}
+		}
+	}
+	return "Collected"
+}
+
+// printLatestEvents prints the latest kube-log-helper event of every pod.
+func printLatestEvents(ctx context.Context, c client.Client, pods []corev1.Pod) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	header := false
+	for _, pod := range pods {
+		events := &corev1.EventList{}
+		if err := c.List(ctx, events, client.InNamespace(pod.Namespace), client.MatchingFields{
+			"involvedObject.kind": "Pod",
+			"involvedObject.name": pod.Name,
+		}); err != nil {
+			return err
+		}
+		var latest *corev1.Event
+		for i, event := range events.Items {
+			if event.Source.Component != eventSource {
+				continue
+			}
+			if latest == nil || event.LastTimestamp.After(latest.LastTimestamp.Time) {
+				latest = &events.Items[i]
+			}
+		}
+		if latest == nil {
+			continue
+		}
+		if !header {
+			fmt.Fprintln(w, "\nPOD\tTYPE\tREASON\tLAST SEEN\tMESSAGE")
+			header = true
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", pod.Name, latest.Type, latest.Reason,
+			time.Since(latest.LastTimestamp.Time).Round(time.Second), latest.Message)
+	}
+	return w.Flush()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,75 @@
+package controllers
+
+import (
+	"fmt"
+	"k8s.io/apimachinery/pkg/labels"
+	"os"
+)
+
+// AdmissionOptions decides per log source whether this cluster collects it.
+// The selector is evaluated against a flat label set:
+//
+//	namespace=<pod namespace>
+//	tags.<key>=<log source tag>
+//	labels.<key>=<pod label>
+//	node.<key>=<node label>
+type AdmissionOptions struct {
+	expression string
+	selector   labels.Selector
+}
+
+type admissionDecision struct {
+	container string
+	source    string
+	admitted  bool
+}
+
+func admissionInit() (*AdmissionOptions, error) {
+	// e.g: LOGGING_ADMISSION_SELECTOR="tags.env in (test,staging),namespace!=kube-system"
+	expression := os.Getenv(EnvLoggingAdmissionSelector)
+	if expression == "" {
+		// CLUSTER_ENV_NAME is kept as a shorthand of "tags.env=<cluster env>"
+		if clusterName := os.Getenv(EnvClusterEnvName); clusterName != "" {
+			expression = "tags.env=" + clusterName
+		}
+	}
+	if expression == "" {
+		return nil, nil
+	}
+	selector, err := labels.Parse(expression)
+	if err != nil {
+		return nil, fmt.Errorf("invalid admission selector %q: %v", expression, err)
+	}
+	return &AdmissionOptions{
+		expression: expression,
+		selector:   selector,
+	}, nil
+}
+
+func (a *AdmissionOptions) admit(set labels.Set) bool {
+	if a == nil {
+		return true
+	}
+	return a.selector.Matches(set)
+}
+
+func (clp *ContainerLogOptions) admissionLabels(tagsMap map[string]string) labels.Set {
+	set := labels.Set{"namespace": clp.namespace}
+	for k, v := range tagsMap {
+		set["tags."+k] = v
+	}
+	for k, v := range clp.podLabels {
+		set["labels."+k] = v
+	}
+	for k, v := range clp.nodeLabels {
+		set["node."+k] = v
+	}
+	return set
+}
+
+func (d admissionDecision) message(expression string) string {
+	if d.admitted {
+		return fmt.Sprintf("log source %s of container %s is collected", d.source, d.container)
+	}
+	return fmt.Sprintf("log source %s of container %s is not admitted by %q", d.source, d.container, expression)
+}
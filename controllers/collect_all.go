@@ -0,0 +1,88 @@
+package controllers
+
+import (
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// CollectAllOptions collects the stdout of every container that has no log declaration,
+// unless the namespace, pod or container is excluded.
+type CollectAllOptions struct {
+	excludeNamespaces map[string]bool
+	excludePods       labels.Selector
+	excludeContainers *regexp.Regexp
+}
+
+func collectAllInit() (*CollectAllOptions, error) {
+	if os.Getenv(EnvLoggingCollectAll) != "true" {
+		return nil, nil
+	}
+	opts := &CollectAllOptions{
+		excludeNamespaces: make(map[string]bool),
+		excludePods:       labels.Nothing(),
+	}
+	// e.g: LOGGING_EXCLUDE_NAMESPACES="kube-system,monitoring"
+	for _, ns := range strings.Split(os.Getenv(EnvLoggingExcludeNamespaces), ",") {
+		if ns = strings.TrimSpace(ns); ns != "" {
+			opts.excludeNamespaces[ns] = true
+		}
+	}
+	// e.g: LOGGING_EXCLUDE_POD_SELECTOR="app in (canary),tier=batch"
+	if selector := os.Getenv(EnvLoggingExcludePodSelector); selector != "" {
+		parsed, err := labels.Parse(selector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", EnvLoggingExcludePodSelector, err)
+		}
+		opts.excludePods = parsed
+	}
+	// e.g: LOGGING_EXCLUDE_CONTAINERS="^(istio-proxy|linkerd-proxy)$"
+	if expr := os.Getenv(EnvLoggingExcludeContainers); expr != "" {
+		parsed, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", EnvLoggingExcludeContainers, err)
+		}
+		opts.excludeContainers = parsed
+	}
+	return opts, nil
+}
+
+func (c *CollectAllOptions) includePod(pod *corev1.Pod) bool {
+	if c == nil {
+		return false
+	}
+	if c.excludeNamespaces[pod.Namespace] {
+		return false
+	}
+	if pod.Annotations[AnnotationExclude] == "true" {
+		return false
+	}
+	return !c.excludePods.Matches(labels.Set(pod.Labels))
+}
+
+func (c *CollectAllOptions) includeContainer(name string) bool {
+	return c.excludeContainers == nil || !c.excludeContainers.MatchString(name)
+}
+
+// workloadName resolves the name of the workload that owns the pod, ReplicaSets
+// are followed up to their Deployment through the pod-template-hash label.
+func workloadName(pod *corev1.Pod) string {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return pod.Name
+	}
+	if owner.Kind == "ReplicaSet" {
+		if hash := pod.Labels["pod-template-hash"]; hash != "" {
+			return strings.TrimSuffix(owner.Name, "-"+hash)
+		}
+	}
+	return owner.Name
+}
+
+func workloadIndex(pod *corev1.Pod) string {
+	return fmt.Sprintf("%s-%s", pod.Namespace, workloadName(pod))
+}
@@ -5,14 +5,80 @@ const (
 	FilebeatBin         string = "/usr/bin/filebeat"
 	FilebeatConf        string = FilebeatBase + "/filebeat.yml"
 	FilebeatConfDir     string = FilebeatBase + "/inputs.d"
-	AlreadyStartedError string = "already started"
+	FilebeatRegistryDir string = "/var/lib/filebeat/data/registry/filebeat"
+	// queue.disk path of filebeat.yml, ${path.data}/diskqueue
+	FilebeatDiskQueueDir string = "/var/lib/filebeat/data/diskqueue"
+	FilebeatHTTPSocket   string = "unix:///var/lib/filebeat/filebeat.sock"
+	AlreadyStartedError  string = "already started"
 
+	KubeletPodsDir                   string = "/var/lib/kubelet/pods"
 	EnvLoggingPath                   string = "/var/log/containers"
 	EnvLoggingPrefix                 string = "LOGGING_INDEX_PREFIX" + "_logs_"
 	EnvClusterEnvName                string = "CLUSTER_ENV_NAME"
+	EnvNodeName                      string = "NODE_NAME"
+	EnvLoggingCollectAll             string = "LOGGING_COLLECT_ALL"
+	EnvLoggingExcludeNamespaces      string = "LOGGING_EXCLUDE_NAMESPACES"
+	EnvLoggingExcludePodSelector     string = "LOGGING_EXCLUDE_POD_SELECTOR"
+	EnvLoggingExcludeContainers      string = "LOGGING_EXCLUDE_CONTAINERS"
+	EnvLoggingAdmissionSelector      string = "LOGGING_ADMISSION_SELECTOR"
+	EnvLoggingLagThresholdBytes      string = "LOGGING_LAG_THRESHOLD_BYTES"
+	EnvLoggingStallMinutes           string = "LOGGING_STALL_MINUTES"
+	EnvLoggingMaxRateLimit           string = "LOGGING_MAX_RATE_LIMIT"
+	EnvLoggingMaxRateLimitBytes      string = "LOGGING_MAX_RATE_LIMIT_BYTES"
+	EnvLoggingMaxBytes               string = "LOGGING_MAX_BYTES"
+	EnvLoggingRedactionRulesFile     string = "LOGGING_REDACTION_RULES_FILE"
+	EnvLoggingIndexTemplate          string = "LOGGING_INDEX_TEMPLATE"
+	EnvLoggingTopicTemplate          string = "LOGGING_TOPIC_TEMPLATE"
+	EnvLoggingIndexEnforcedPrefix    string = "LOGGING_INDEX_ENFORCED_PREFIX"
+	EnvElasticsearchHosts            string = "ELASTICSEARCH_HOSTS"
+	EnvElasticsearchUsername         string = "ELASTICSEARCH_USERNAME"
+	EnvElasticsearchPassword         string = "ELASTICSEARCH_PASSWORD"
+	EnvKafkaBrokers                  string = "KAFKA_BROKERS"
+	EnvKafkaVersion                  string = "KAFKA_VERSION"
+	EnvKafkaTopicPartitions          string = "KAFKA_TOPIC_PARTITIONS"
+	EnvKafkaTopicReplicationFactor   string = "KAFKA_TOPIC_REPLICATION_FACTOR"
+	EnvKafkaTopicRetention           string = "KAFKA_TOPIC_RETENTION"
+	EnvKafkaTopicAllowlist           string = "KAFKA_TOPIC_ALLOWLIST"
 	EnvFilebeatLogLevel              string = "FILEBEAT_LOG_LEVEL"
 	EnvFilebeatMetricsEnabled        string = "FILEBEAT_METRICS_ENABLED"
 	EnvFilebeatFilesRotateeverybytes string = "FILEBEAT_FILES_ROTATEEVERYBYTES"
 	EnvFilebeatMaxProcs              string = "FILEBEAT_MAX_PROCS"
 	EnvFilebeatSetupIlmEnabled       string = "FILEBEAT_SETUP_ILM_ENABLED"
+	EnvFilebeatQueueDiskMaxSize      string = "FILEBEAT_QUEUE_DISK_MAX_SIZE"
+	EnvFilebeatConfigMap             string = "FILEBEAT_CONFIGMAP"
+	EnvFilebeatHTTPHost              string = "FILEBEAT_HTTP_HOST"
+	EnvFilebeatHTTPPort              string = "FILEBEAT_HTTP_PORT"
+	EnvSidecarImage                  string = "SIDECAR_IMAGE"
+	EnvPodName                       string = "POD_NAME"
+	EnvPodNamespace                  string = "POD_NAMESPACE"
+
+	SidecarLogsDir     string = "/var/log/kube-log-helper"
+	SidecarPodInfoDir  string = "/etc/kube-log-helper/podinfo"
+	SidecarModeVolume  string = "volume"
+	SidecarModeShipper string = "shipper"
+
+	AnnotationExclude string = "logs.kube-log-helper/exclude"
+	// volume mounts emptyDirs for log paths not on a volume, shipper also injects a filebeat sidecar
+	AnnotationSidecar       string = "logs.kube-log-helper/sidecar"
+	AnnotationSidecarInputs string = "logs.kube-log-helper/sidecar-inputs"
+	// shorthand on workloads expanded into k8s_logs_<container> env by the workload webhook
+	AnnotationStdout     string = "logs.kube-log-helper/stdout"
+	AnnotationContainers string = "logs.kube-log-helper/containers"
+	AnnotationStdoutEnv  string = "logs.kube-log-helper/stdout-env"
+	LabelWatchLog        string = "logs.kube-log-helper/watchlog"
+	LabelNode            string = "logs.kube-log-helper/node"
+
+	EventReasonSourceAdmitted      string = "LogSourceAdmitted"
+	EventReasonSourceRejected      string = "LogSourceRejected"
+	EventReasonCollectionStarted   string = "CollectionStarted"
+	EventReasonCollectionStopped   string = "CollectionStopped"
+	EventReasonInvalidLogConfig    string = "InvalidLogConfig"
+	EventReasonPathNotFound        string = "PathNotFound"
+	EventReasonUnsupportedFormat   string = "UnsupportedFormat"
+	EventReasonShippingLagging     string = "ShippingLagging"
+	EventReasonShippingStalled     string = "ShippingStalled"
+	EventReasonShippingRecovered   string = "ShippingRecovered"
+	EventReasonDiskQueueFilling    string = "DiskQueueFilling"
+	EventReasonDiskQueueRecovered  string = "DiskQueueRecovered"
+	EventReasonTopicNotProvisioned string = "TopicNotProvisioned"
 )
@@ -0,0 +1,124 @@
+package controllers
+
+import (
+	"k8s.io/apimachinery/pkg/types"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// SourceStatus describes how one log source of a container is collected.
+type SourceStatus struct {
+	Container string `json:"container"`
+	Source    string `json:"source"`
+	Path      string `json:"path,omitempty"`
+	Index     string `json:"index,omitempty"`
+	Topic     string `json:"topic,omitempty"`
+	Format    string `json:"format,omitempty"`
+	Multiline string `json:"multiline,omitempty"`
+	// Rejected is the admission selector that rejected the source.
+	Rejected string `json:"rejected,omitempty"`
+	// Error is why the source could not be rendered.
+	Error string `json:"error,omitempty"`
+}
+
+// PodSources are the log sources of a pod as the node agent last rendered them,
+// served on /status/pods.
+type PodSources struct {
+	Namespace string         `json:"namespace"`
+	Pod       string         `json:"pod"`
+	Sources   []SourceStatus `json:"sources"`
+}
+
+// podSourceStore keeps the sources of every pod of this node.
+type podSourceStore struct {
+	sync.RWMutex
+	pods map[types.NamespacedName][]SourceStatus
+}
+
+var describedPods = &podSourceStore{
+	pods: make(map[types.NamespacedName][]SourceStatus),
+}
+
+func (s *podSourceStore) set(pod types.NamespacedName, sources []SourceStatus) {
+	s.Lock()
+	defer s.Unlock()
+	s.pods[pod] = sources
+}
+
+func (s *podSourceStore) delete(pod types.NamespacedName) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.pods, pod)
+}
+
+// list returns the pods of namespace, of every namespace when it is empty.
+func (s *podSourceStore) list(namespace string) []PodSources {
+	s.RLock()
+	defer s.RUnlock()
+	pods := make([]PodSources, 0, len(s.pods))
+	for key, sources := range s.pods {
+		if namespace != "" && key.Namespace != namespace {
+			continue
+		}
+		pods = append(pods, PodSources{Namespace: key.Namespace, Pod: key.Name, Sources: sources})
+	}
+	sort.Slice(pods, func(i, j int) bool {
+		if pods[i].Namespace != pods[j].Namespace {
+			return pods[i].Namespace < pods[j].Namespace
+		}
+		return pods[i].Pod < pods[j].Pod
+	})
+	return pods
+}
+
+// sourceStatuses describes the rendered, rejected and failed log sources of the pod.
+func (clp *ContainerLogOptions) sourceStatuses(admission *AdmissionOptions) []SourceStatus {
+	sources := make([]SourceStatus, 0)
+	for _, input := range clp.inputConfigList {
+		sources = append(sources, SourceStatus{
+			Container: input.Tags["k8s_container_name"],
+			Source:    input.Name,
+			Path:      filepath.Join(input.HostDir, input.File),
+			Index:     input.Index,
+			Topic:     input.Tags["topic"],
+			Format:    input.Format,
+			Multiline: multilinePreset(input.MultilinePattern),
+		})
+	}
+	for _, decision := range clp.decisions {
+		if !decision.admitted {
+			sources = append(sources, SourceStatus{
+				Container: decision.container,
+				Source:    decision.source,
+				Rejected:  admission.expression,
+			})
+		}
+	}
+	for _, failure := range clp.failures {
+		sources = append(sources, SourceStatus{
+			Container: failure.container,
+			Source:    failure.source,
+			Error:     failure.err.Error(),
+		})
+	}
+	sort.SliceStable(sources, func(i, j int) bool {
+		if sources[i].Container != sources[j].Container {
+			return sources[i].Container < sources[j].Container
+		}
+		return sources[i].Source < sources[j].Source
+	})
+	return sources
+}
+
+func multilinePreset(pattern string) string {
+	if pattern == "" {
+		return ""
+	}
+	for preset, p := range multilinePresets {
+		if p == pattern {
+			return preset
+		}
+	}
+	return pattern
+}
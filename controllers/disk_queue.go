@@ -0,0 +1,150 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	diskQueueCheckInterval = 30 * time.Second
+	// on-call is warned above the first ratio, told it recovered below the second
+	diskQueueWarnRatio    = 0.8
+	diskQueueRecoverRatio = 0.7
+)
+
+var (
+	byteSizePattern = regexp.MustCompile(`^([0-9]+(?:\.[0-9]+)?)\s*([kmgt]i?b|b)?$`)
+	// units as filebeat reads them, KB is 1000 bytes and KiB 1024
+	byteSizeUnits = map[string]float64{
+		"": 1, "b": 1,
+		"kb": 1e3, "mb": 1e6, "gb": 1e9, "tb": 1e12,
+		"kib": 1 << 10, "mib": 1 << 20, "gib": 1 << 30, "tib": 1 << 40,
+	}
+
+	// max_size of the rendered queue.disk, 0 when filebeat keeps its queue in memory
+	diskQueueLimit int64
+)
+
+// parseByteSize parses a filebeat size, e.g. 10GB or 512MiB.
+func parseByteSize(value string) (int64, error) {
+	match := byteSizePattern.FindStringSubmatch(strings.ToLower(strings.TrimSpace(value)))
+	if match == nil {
+		return 0, fmt.Errorf("invalid size %q, expected e.g. 10GB or 512MiB", value)
+	}
+	n, err := strconv.ParseFloat(match[1], 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q, expected e.g. 10GB or 512MiB", value)
+	}
+	return int64(n * byteSizeUnits[match[2]]), nil
+}
+
+// diskQueueMaxSize validates the queue.disk max_size of the settings and records it
+// for the DiskQueueMonitor.
+func diskQueueMaxSize(settings filebeatSettings) (string, error) {
+	value := settings.get(EnvFilebeatQueueDiskMaxSize)
+	var limit int64
+	if value != "" {
+		var err error
+		if limit, err = parseByteSize(value); err != nil {
+			return "", fmt.Errorf("%s: %v", EnvFilebeatQueueDiskMaxSize, err)
+		}
+	}
+	atomic.StoreInt64(&diskQueueLimit, limit)
+	return value, nil
+}
+
+// DiskQueueMonitor reports how full the filebeat disk queue is. Once it is full
+// filebeat stops reading, and logs are lost when their files rotate away, so a
+// warning event is recorded on the node before that happens.
+type DiskQueueMonitor struct {
+	recorder record.EventRecorder
+	nodeName string
+	layout   FilebeatLayout
+	filling  bool
+}
+
+func NewDiskQueueMonitor(recorder record.EventRecorder, layout FilebeatLayout) *DiskQueueMonitor {
+	return &DiskQueueMonitor{
+		recorder: recorder,
+		nodeName: os.Getenv(EnvNodeName),
+		layout:   layout,
+	}
+}
+
+// NeedLeaderElection is false, every node watches its own queue.
+func (m *DiskQueueMonitor) NeedLeaderElection() bool {
+	return false
+}
+
+func (m *DiskQueueMonitor) Start(ctx context.Context) error {
+	ticker := time.NewTicker(diskQueueCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			m.check()
+		}
+	}
+}
+
+func (m *DiskQueueMonitor) check() {
+	limit := atomic.LoadInt64(&diskQueueLimit)
+	size, err := dirSize(m.layout.FS, m.layout.DiskQueueDir)
+	if err != nil {
+		klog.Warningf("unable to measure the filebeat disk queue: %v", err)
+		return
+	}
+	diskQueueBytes.Set(float64(size))
+	diskQueueMaxBytes.Set(float64(limit))
+	if limit == 0 {
+		diskQueueFillRatio.Set(0)
+		return
+	}
+	ratio := float64(size) / float64(limit)
+	diskQueueFillRatio.Set(ratio)
+
+	node := &corev1.ObjectReference{Kind: "Node", Name: m.nodeName, UID: types.UID(m.nodeName)}
+	switch {
+	case ratio >= diskQueueWarnRatio && !m.filling:
+		m.filling = true
+		message := fmt.Sprintf("filebeat disk queue is %.0f%% full (%d of %d bytes), the outputs are not keeping up and logs are lost once it is full", ratio*100, size, limit)
+		klog.Warning(message)
+		m.recorder.Event(node, corev1.EventTypeWarning, EventReasonDiskQueueFilling, message)
+	case ratio < diskQueueRecoverRatio && m.filling:
+		m.filling = false
+		message := fmt.Sprintf("filebeat disk queue is back to %.0f%% full", ratio*100)
+		klog.Info(message)
+		m.recorder.Event(node, corev1.EventTypeNormal, EventReasonDiskQueueRecovered, message)
+	}
+}
+
+// dirSize is the size of the files under dir, 0 when it does not exist yet.
+func dirSize(fsys FileSystem, dir string) (int64, error) {
+	var size int64
+	err := fsys.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// a segment removed once acknowledged
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
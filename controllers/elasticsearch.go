@@ -0,0 +1,103 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const elasticsearchTimeout = 10 * time.Second
+
+// ElasticsearchClient calls the elasticsearch cluster the filebeat output ships to,
+// trying the hosts in order until one answers.
+type ElasticsearchClient struct {
+	hosts    []string
+	username string
+	password string
+	client   *http.Client
+}
+
+// NewElasticsearchClient returns nil when ELASTICSEARCH_HOSTS is not set.
+func NewElasticsearchClient() *ElasticsearchClient {
+	// e.g: ELASTICSEARCH_HOSTS="https://es-0:9200,https://es-1:9200"
+	hosts := os.Getenv(EnvElasticsearchHosts)
+	if hosts == "" {
+		return nil
+	}
+	return newElasticsearchClient(strings.Split(hosts, ","), os.Getenv(EnvElasticsearchUsername), os.Getenv(EnvElasticsearchPassword))
+}
+
+func newElasticsearchClient(hosts []string, username, password string) *ElasticsearchClient {
+	for i := range hosts {
+		hosts[i] = strings.TrimSuffix(strings.TrimSpace(hosts[i]), "/")
+	}
+	return &ElasticsearchClient{
+		hosts:    hosts,
+		username: username,
+		password: password,
+		client:   &http.Client{Timeout: elasticsearchTimeout},
+	}
+}
+
+// get decodes the object at path into out, it returns false when there is none.
+func (c *ElasticsearchClient) get(ctx context.Context, path string, out interface{}) (bool, error) {
+	body, status, err := c.do(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return false, err
+	}
+	if status == http.StatusNotFound {
+		return false, nil
+	}
+	if status != http.StatusOK {
+		return false, fmt.Errorf("GET %s: %d %s", path, status, body)
+	}
+	return true, json.Unmarshal(body, out)
+}
+
+func (c *ElasticsearchClient) put(ctx context.Context, path string, in interface{}) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	body, status, err := c.do(ctx, http.MethodPut, path, data)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("PUT %s: %d %s", path, status, body)
+	}
+	return nil
+}
+
+func (c *ElasticsearchClient) do(ctx context.Context, method, path string, data []byte) ([]byte, int, error) {
+	var lastErr error
+	for _, host := range c.hosts {
+		req, err := http.NewRequestWithContext(ctx, method, host+path, bytes.NewReader(data))
+		if err != nil {
+			return nil, 0, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if c.username != "" {
+			req.SetBasicAuth(c.username, c.password)
+		}
+		resp, err := c.client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return body, resp.StatusCode, nil
+	}
+	return nil, 0, lastErr
+}
@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"fmt"
+	crdk8sv1alpha1 "github.com/cccfs/kube-log-helper/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+type PathNotFoundError struct {
+	Path   string
+	Reason string
+	// Unmounted is true when no volume of the container holds the path,
+	// the sidecar webhook can mount one there.
+	Unmounted bool
+}
+
+func (e *PathNotFoundError) Error() string {
+	return fmt.Sprintf("%s %s", e.Path, e.Reason)
+}
+
+type UnsupportedFormatError struct {
+	Format string
+}
+
+func (e *UnsupportedFormatError) Error() string {
+	return fmt.Sprintf("unsupported log format: %s", e.Format)
+}
+
+type sourceFailure struct {
+	container string
+	source    string
+	prefix    string
+	// WatchLog declaring the log source, empty when the pod declares it
+	watchLog string
+	err      error
+}
+
+func (f sourceFailure) reason() string {
+	switch f.err.(type) {
+	case *PathNotFoundError:
+		return EventReasonPathNotFound
+	case *UnsupportedFormatError:
+		return EventReasonUnsupportedFormat
+	}
+	return EventReasonInvalidLogConfig
+}
+
+func (f sourceFailure) message() string {
+	return fmt.Sprintf("log source %s of container %s: %v", f.source, f.container, f.err)
+}
+
+// podEventRecorder records an event on the pod and on every WatchLog selecting it,
+// so both `kubectl describe pod` and `kubectl describe watchlog` show it.
+type podEventRecorder struct {
+	recorder  record.EventRecorder
+	pod       *corev1.Pod
+	watchLogs []crdk8sv1alpha1.WatchLog
+}
+
+func (e *podEventRecorder) event(eventtype, reason, message string) {
+	if e.recorder == nil {
+		return
+	}
+	e.recorder.Event(e.pod, eventtype, reason, message)
+	for i := range e.watchLogs {
+		e.recorder.Eventf(&e.watchLogs[i], eventtype, reason, "pod %s: %s", e.pod.Name, message)
+	}
+}
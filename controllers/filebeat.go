@@ -1,34 +1,58 @@
 package controllers
 
 import (
-	"io/ioutil"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
 	"k8s.io/klog/v2"
 	"os"
-	"os/exec"
+	"path/filepath"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
+const filebeatStopTimeout = 30 * time.Second
+
+// reasons of filebeat_restarts_total, a stopped filebeat is a planned restart
+const (
+	restartReasonExited  = "exited"
+	restartReasonStopped = "stopped"
+)
+
+// ErrFilebeatNotSupervised is returned by WithFilebeatStopped when filebeat runs outside the helper.
+var ErrFilebeatNotSupervised = errors.New("filebeat is not supervised by the helper, it cannot be stopped")
+
 type LogHelperEntry struct {
 	filebeatCtrl FilebeatCtrlInterface
 }
 
-func Run() error {
-	_, err := BeforeRun()
-	//logHelper, err := BeforeRun()
+// Run writes filebeat.yml and, unless filebeat runs outside the helper, starts it.
+func Run(layout FilebeatLayout, startFilebeat bool) (*LogHelperEntry, error) {
+	logHelper, err := BeforeRun(layout)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if !startFilebeat {
+		return logHelper, nil
 	}
 
-	// development mode annotations the feature
-	//err = logHelper.filebeatCtrl.StartFilebeat()
-	//if err != nil && AlreadyStartedError != err.Error() {
-	//	return err
-	//}
-	return nil
+	err = logHelper.filebeatCtrl.StartFilebeat()
+	if err != nil && AlreadyStartedError != err.Error() {
+		return nil, err
+	}
+	return logHelper, nil
 }
 
-func BeforeRun() (*LogHelperEntry, error) {
-	ctrl, err := InitFilebeat()
+func (e *LogHelperEntry) FilebeatCtrl() FilebeatCtrlInterface {
+	return e.filebeatCtrl
+}
+
+func BeforeRun(layout FilebeatLayout) (*LogHelperEntry, error) {
+	ctrl, err := InitFilebeat(layout)
 	if err != nil {
 		return nil, err
 	}
@@ -40,26 +64,62 @@ func BeforeRun() (*LogHelperEntry, error) {
 type FilebeatCtrlInterface interface {
 	StartFilebeat() error
 	StopFilebeat() error
+	WithFilebeatStopped(fn func() error) error
+	Status() FilebeatStatus
 }
 
 type FilebeatCtrlOptions struct {
+	layout         FilebeatLayout
 	watchDone      chan bool
 	watchDuration  time.Duration
 	watchContainer map[string]string
+	stopRequests   chan stopRequest
+	supervised     int32
+
+	// process state reported by Status
+	mu        sync.Mutex
+	pid       int
+	startedAt time.Time
+	restarts  int64
+}
+
+// FilebeatStatus is the state of the filebeat process run by the helper.
+type FilebeatStatus struct {
+	Running    bool       `json:"running"`
+	Supervised bool       `json:"supervised"`
+	PID        int        `json:"pid,omitempty"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	Uptime     string     `json:"uptime,omitempty"`
+	Restarts   int64      `json:"restarts"`
 }
 
-func InitFilebeat() (FilebeatCtrlInterface, error) {
-	config, err := filebeatConfigParse()
+// stopRequest asks the supervisor to run fn while filebeat is stopped.
+type stopRequest struct {
+	fn   func() error
+	done chan error
+}
+
+func InitFilebeat(layout FilebeatLayout) (FilebeatCtrlInterface, error) {
+	// the settings ConfigMap is applied by FilebeatConfigReconciler once the manager runs
+	config, err := filebeatConfigParse(nil)
 	if err != nil {
 		return nil, err
 	}
-	if err := ioutil.WriteFile(FilebeatConf, []byte(config), 0600); err != nil {
-		klog.Exitf("unable to write %s: %v", FilebeatConf, err)
+	if _, err := layout.writeFileIfChanged(layout.Conf, config); err != nil {
+		return nil, err
+	}
+	collector := newFilebeatStatsCollector(filebeatHTTPHost(), os.Getenv(EnvFilebeatHTTPPort), layout)
+	if err := metrics.Registry.Register(collector); err != nil {
+		if _, ok := err.(prometheus.AlreadyRegisteredError); !ok {
+			return nil, err
+		}
 	}
 	return &FilebeatCtrlOptions{
+		layout:         layout,
 		watchDone:      make(chan bool),
 		watchDuration:  10 * time.Second,
 		watchContainer: make(map[string]string, 0),
+		stopRequests:   make(chan stopRequest),
 	}, nil
 }
 
@@ -69,25 +129,131 @@ func (f *FilebeatCtrlOptions) StopFilebeat() error {
 }
 
 func (f *FilebeatCtrlOptions) StartFilebeat() error {
-	cmd := exec.Command(FilebeatBin, "-c", FilebeatConf)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	err := cmd.Start()
-
-	// wait filebeat start success
-	go func() {
-		if err := cmd.Wait(); err != nil {
-			// filebeat start failed program exit
-			if _, ok := err.(*exec.ExitError); ok {
-				f.StopFilebeat()
+	process, err := f.startProcess()
+	if err != nil {
+		return err
+	}
+
+	// wait filebeat exit and restart it, supervised is set before WithFilebeatStopped can be called
+	atomic.StoreInt32(&f.supervised, 1)
+	go f.superviseFilebeat(process)
+
+	go f.watchContainerLoop()
+
+	return nil
+}
+
+func (f *FilebeatCtrlOptions) startProcess() (Process, error) {
+	process, err := f.layout.Runner.Start(f.layout.Bin, "-c", f.layout.Conf)
+	if err != nil {
+		filebeatUp.Set(0)
+		return nil, err
+	}
+	f.mu.Lock()
+	f.pid, f.startedAt = process.Pid(), time.Now()
+	f.mu.Unlock()
+	filebeatUp.Set(1)
+	return process, nil
+}
+
+func (f *FilebeatCtrlOptions) processExited() {
+	f.mu.Lock()
+	f.pid = 0
+	f.mu.Unlock()
+	filebeatUp.Set(0)
+}
+
+func (f *FilebeatCtrlOptions) Status() FilebeatStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	status := FilebeatStatus{
+		Running:    f.pid != 0,
+		Supervised: atomic.LoadInt32(&f.supervised) == 1,
+		PID:        f.pid,
+		Restarts:   f.restarts,
+	}
+	if f.pid != 0 {
+		startedAt := f.startedAt
+		status.StartedAt = &startedAt
+		status.Uptime = time.Since(startedAt).Round(time.Second).String()
+	}
+	return status
+}
+
+// superviseFilebeat restarts filebeat whenever it exits, the delay doubles up to
+// a minute while filebeat keeps crashing within a minute of being started.
+func (f *FilebeatCtrlOptions) superviseFilebeat(process Process) {
+	backoff := time.Second
+	for {
+		started := time.Now()
+		reason := restartReasonExited
+		exited := make(chan error, 1)
+		go func(process Process) {
+			exited <- process.Wait()
+		}(process)
+
+		select {
+		case err := <-exited:
+			f.processExited()
+			klog.Errorf("filebeat exited: %v", err)
+			if time.Since(started) > time.Minute {
+				backoff = time.Second
+			}
+			time.Sleep(backoff)
+			if backoff < time.Minute {
+				backoff *= 2
 			}
-			klog.Exitf("filebeat exited: %v", err.(*exec.ExitError))
+		case req := <-f.stopRequests:
+			reason = restartReasonStopped
+			f.stopProcess(process, exited)
+			req.done <- req.fn()
 		}
-	}()
 
-	go f.watchContainerLoop()
+		var err error
+		for {
+			filebeatRestarts.WithLabelValues(reason).Inc()
+			f.mu.Lock()
+			f.restarts++
+			f.mu.Unlock()
+			if process, err = f.startProcess(); err == nil {
+				break
+			}
+			klog.Errorf("unable to restart filebeat: %v", err)
+			time.Sleep(backoff)
+			if backoff < time.Minute {
+				backoff *= 2
+			}
+		}
+	}
+}
 
-	return err
+// stopProcess asks filebeat to shut down, it is killed when it does not exit in time.
+func (f *FilebeatCtrlOptions) stopProcess(process Process, exited chan error) {
+	if err := process.Signal(syscall.SIGTERM); err != nil {
+		klog.Errorf("unable to stop filebeat: %v", err)
+	}
+	select {
+	case <-exited:
+	case <-time.After(filebeatStopTimeout):
+		klog.Warningf("filebeat did not stop within %s, killing it", filebeatStopTimeout)
+		_ = process.Kill()
+		<-exited
+	}
+	f.processExited()
+}
+
+// WithFilebeatStopped runs fn while filebeat is stopped and starts it again afterwards.
+// It fails when filebeat is not started by the helper, fn would run next to a live filebeat.
+func (f *FilebeatCtrlOptions) WithFilebeatStopped(fn func() error) error {
+	if atomic.LoadInt32(&f.supervised) == 0 {
+		return ErrFilebeatNotSupervised
+	}
+	req := stopRequest{
+		fn:   fn,
+		done: make(chan error, 1),
+	}
+	f.stopRequests <- req
+	return <-req.done
 }
 
 func (f *FilebeatCtrlOptions) watchContainerLoop() error {
@@ -101,3 +267,48 @@ func (f *FilebeatCtrlOptions) watchContainerLoop() error {
 		}
 	}
 }
+
+func (l FilebeatLayout) inputConfigFile(namespace, podName string) string {
+	return filepath.Join(l.ConfDir, fmt.Sprintf("%s_%s.yml", namespace, podName))
+}
+
+// WriteInputConfig writes a pod's inputs into the filebeat reload directory,
+// it returns false when the file on disk already has the same content.
+func (l FilebeatLayout) WriteInputConfig(file, config string) (bool, error) {
+	start := time.Now()
+	defer func() {
+		inputWriteDuration.Observe(time.Since(start).Seconds())
+	}()
+
+	return l.writeFileIfChanged(file, config)
+}
+
+// fileChanged reports whether file is missing or has a content other than config.
+func (l FilebeatLayout) fileChanged(file, config string) bool {
+	current, err := l.FS.ReadFile(file)
+	return err != nil || !bytes.Equal(current, []byte(config))
+}
+
+func (l FilebeatLayout) writeFileIfChanged(file, config string) (bool, error) {
+	if !l.fileChanged(file, config) {
+		return false, nil
+	}
+	if err := l.FS.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return false, err
+	}
+	if err := l.FS.WriteFile(file, []byte(config), 0600); err != nil {
+		return false, fmt.Errorf("unable to write %s: %v", file, err)
+	}
+	return true, nil
+}
+
+// RemoveInputConfig removes a pod's inputs, it returns false when there was nothing to remove.
+func (l FilebeatLayout) RemoveInputConfig(file string) (bool, error) {
+	if err := l.FS.Remove(file); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,83 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	"os"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"strings"
+)
+
+// FilebeatConfigMap returns the ConfigMap holding the global filebeat settings,
+// set as FILEBEAT_CONFIGMAP=<namespace>/<name>.
+func FilebeatConfigMap() (types.NamespacedName, bool, error) {
+	value := os.Getenv(EnvFilebeatConfigMap)
+	if value == "" {
+		return types.NamespacedName{}, false, nil
+	}
+	parts := strings.Split(value, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return types.NamespacedName{}, false, fmt.Errorf("invalid %s %q, expected <namespace>/<name>", EnvFilebeatConfigMap, value)
+	}
+	return types.NamespacedName{Namespace: parts[0], Name: parts[1]}, true, nil
+}
+
+// FilebeatConfigReconciler re-renders filebeat.yml when the settings ConfigMap changes,
+// filebeat is restarted only when the rendered file differs from the one on disk.
+type FilebeatConfigReconciler struct {
+	client.Client
+	Filebeat  FilebeatCtrlInterface
+	Layout    FilebeatLayout
+	ConfigMap types.NamespacedName
+}
+
+//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch
+
+func (r *FilebeatConfigReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	settings := filebeatSettings{}
+	configMap := &corev1.ConfigMap{}
+	if err := r.Client.Get(ctx, r.ConfigMap, configMap); err != nil {
+		if !errors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+		// a deleted ConfigMap falls back to the env settings
+	} else {
+		settings = configMap.Data
+	}
+
+	config, err := filebeatConfigParse(settings)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if !r.Layout.fileChanged(r.Layout.Conf, config) {
+		return ctrl.Result{}, nil
+	}
+
+	klog.Infof("filebeat settings of configmap %s changed, restarting filebeat to apply %s", r.ConfigMap, r.Layout.Conf)
+	err = r.Filebeat.WithFilebeatStopped(func() error {
+		_, err := r.Layout.writeFileIfChanged(r.Layout.Conf, config)
+		return err
+	})
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	filebeatConfigReloads.Inc()
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *FilebeatConfigReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("filebeatconfig").
+		For(&corev1.ConfigMap{}, builder.WithPredicates(predicate.NewPredicateFuncs(func(obj client.Object) bool {
+			return obj.GetNamespace() == r.ConfigMap.Namespace && obj.GetName() == r.ConfigMap.Name
+		}))).
+		Complete(r)
+}
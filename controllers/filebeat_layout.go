@@ -0,0 +1,136 @@
+package controllers
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// FileSystem is what the helper does with the filebeat config files, registry and disk queue.
+type FileSystem interface {
+	ReadFile(name string) ([]byte, error)
+	WriteFile(name string, data []byte, perm os.FileMode) error
+	MkdirAll(path string, perm os.FileMode) error
+	Remove(name string) error
+	Stat(name string) (os.FileInfo, error)
+	Open(name string) (io.ReadCloser, error)
+	Rename(from, to string) error
+	Truncate(name string, size int64) error
+	Glob(pattern string) ([]string, error)
+	Walk(root string, fn filepath.WalkFunc) error
+}
+
+// Runner starts the filebeat process.
+type Runner interface {
+	Start(name string, args ...string) (Process, error)
+}
+
+// Process is a started filebeat process.
+type Process interface {
+	Pid() int
+	Signal(sig os.Signal) error
+	Kill() error
+	Wait() error
+}
+
+// FilebeatLayout is where filebeat, its config files and its data are, and how they
+// are read, written and run. Tests pass a fake file system and runner.
+type FilebeatLayout struct {
+	Bin          string
+	Conf         string
+	ConfDir      string
+	RegistryDir  string
+	DiskQueueDir string
+	FS           FileSystem
+	Runner       Runner
+}
+
+// DefaultFilebeatLayout is filebeat as installed in the agent image.
+func DefaultFilebeatLayout() FilebeatLayout {
+	return FilebeatLayout{
+		Bin:          FilebeatBin,
+		Conf:         FilebeatConf,
+		ConfDir:      FilebeatConfDir,
+		RegistryDir:  FilebeatRegistryDir,
+		DiskQueueDir: FilebeatDiskQueueDir,
+		FS:           osFileSystem{},
+		Runner:       execRunner{},
+	}
+}
+
+type osFileSystem struct{}
+
+func (osFileSystem) ReadFile(name string) ([]byte, error) {
+	return ioutil.ReadFile(name)
+}
+
+func (osFileSystem) WriteFile(name string, data []byte, perm os.FileMode) error {
+	return ioutil.WriteFile(name, data, perm)
+}
+
+func (osFileSystem) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+func (osFileSystem) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFileSystem) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFileSystem) Open(name string) (io.ReadCloser, error) {
+	return os.Open(name)
+}
+
+func (osFileSystem) Rename(from, to string) error {
+	return os.Rename(from, to)
+}
+
+func (osFileSystem) Truncate(name string, size int64) error {
+	return os.Truncate(name, size)
+}
+
+func (osFileSystem) Glob(pattern string) ([]string, error) {
+	return filepath.Glob(pattern)
+}
+
+func (osFileSystem) Walk(root string, fn filepath.WalkFunc) error {
+	return filepath.Walk(root, fn)
+}
+
+// execRunner runs filebeat as a child process logging to the helper's output.
+type execRunner struct{}
+
+func (execRunner) Start(name string, args ...string) (Process, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return execProcess{cmd}, nil
+}
+
+type execProcess struct {
+	cmd *exec.Cmd
+}
+
+func (p execProcess) Pid() int {
+	return p.cmd.Process.Pid
+}
+
+func (p execProcess) Signal(sig os.Signal) error {
+	return p.cmd.Process.Signal(sig)
+}
+
+func (p execProcess) Kill() error {
+	return p.cmd.Process.Kill()
+}
+
+func (p execProcess) Wait() error {
+	return p.cmd.Wait()
+}
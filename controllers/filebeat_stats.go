@@ -0,0 +1,242 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog/v2"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const filebeatStatsTimeout = 5 * time.Second
+
+// filebeatHTTPHost is the http.host filebeat serves its monitoring endpoint on,
+// a unix socket unless FILEBEAT_HTTP_HOST asks for a tcp host.
+func filebeatHTTPHost() string {
+	if host := os.Getenv(EnvFilebeatHTTPHost); host != "" {
+		return host
+	}
+	return FilebeatHTTPSocket
+}
+
+// inputID identifies a rendered input as namespace/pod/container/source.
+func inputID(namespace, podName, container, source string) string {
+	return fmt.Sprintf("%s/%s/%s/%s", namespace, podName, container, source)
+}
+
+func parseInputID(id string) ([]string, bool) {
+	parts := strings.SplitN(id, "/", 4)
+	if len(parts) != 4 {
+		return nil, false
+	}
+	return parts, true
+}
+
+type filebeatStats struct {
+	Filebeat struct {
+		Harvester struct {
+			OpenFiles float64 `json:"open_files"`
+			Running   float64 `json:"running"`
+			Started   float64 `json:"started"`
+			Closed    float64 `json:"closed"`
+		} `json:"harvester"`
+	} `json:"filebeat"`
+	Libbeat struct {
+		Output struct {
+			Events struct {
+				Acked   float64 `json:"acked"`
+				Failed  float64 `json:"failed"`
+				Dropped float64 `json:"dropped"`
+			} `json:"events"`
+			Write struct {
+				// histogram in milliseconds, only reported by recent filebeat versions
+				Latency struct {
+					Median *float64 `json:"median"`
+					P95    *float64 `json:"p95"`
+					P99    *float64 `json:"p99"`
+				} `json:"latency"`
+			} `json:"write"`
+		} `json:"output"`
+		Pipeline struct {
+			Events struct {
+				Published float64 `json:"published"`
+				Failed    float64 `json:"failed"`
+				Dropped   float64 `json:"dropped"`
+				Filtered  float64 `json:"filtered"`
+			} `json:"events"`
+		} `json:"pipeline"`
+	} `json:"libbeat"`
+	Registrar struct {
+		States struct {
+			Current float64 `json:"current"`
+		} `json:"states"`
+	} `json:"registrar"`
+}
+
+var (
+	filebeatStatsUpDesc = prometheus.NewDesc(metricsNamespace+"_filebeat_stats_up",
+		"Whether the last scrape of the filebeat monitoring endpoint succeeded.", nil, nil)
+	filebeatHarvestersDesc = prometheus.NewDesc(metricsNamespace+"_filebeat_harvesters",
+		"Number of filebeat harvesters by state.", []string{"state"}, nil)
+	filebeatHarvestersTotalDesc = prometheus.NewDesc(metricsNamespace+"_filebeat_harvesters_total",
+		"Number of filebeat harvesters started or closed.", []string{"event"}, nil)
+	filebeatPipelineEventsDesc = prometheus.NewDesc(metricsNamespace+"_filebeat_pipeline_events_total",
+		"Events handled by the filebeat publisher pipeline by outcome.", []string{"outcome"}, nil)
+	filebeatOutputEventsDesc = prometheus.NewDesc(metricsNamespace+"_filebeat_output_events_total",
+		"Events handled by the filebeat output by outcome.", []string{"outcome"}, nil)
+	filebeatOutputLatencyMedianDesc = prometheus.NewDesc(metricsNamespace+"_filebeat_output_write_latency_median_milliseconds",
+		"Median latency of the output writing a batch, over the recent batches sampled by filebeat.", nil, nil)
+	filebeatOutputLatencyP95Desc = prometheus.NewDesc(metricsNamespace+"_filebeat_output_write_latency_p95_milliseconds",
+		"95th percentile latency of the output writing a batch, over the recent batches sampled by filebeat.", nil, nil)
+	filebeatOutputLatencyP99Desc = prometheus.NewDesc(metricsNamespace+"_filebeat_output_write_latency_p99_milliseconds",
+		"99th percentile latency of the output writing a batch, over the recent batches sampled by filebeat.", nil, nil)
+	filebeatRegistryStatesDesc = prometheus.NewDesc(metricsNamespace+"_filebeat_registry_states",
+		"Number of file states held in the filebeat registry.", nil, nil)
+
+	// filebeat's /inputs/ only reports filestream inputs, the log and container
+	// inputs we render are measured from the registry instead
+	inputLabels            = []string{"namespace", "pod", "container", "source"}
+	filebeatInputFilesDesc = prometheus.NewDesc(metricsNamespace+"_input_files",
+		"Number of files collected by an input.", inputLabels, nil)
+	filebeatInputSizeDesc = prometheus.NewDesc(metricsNamespace+"_input_size_bytes",
+		"Size of the files collected by an input.", inputLabels, nil)
+	filebeatInputOffsetDesc = prometheus.NewDesc(metricsNamespace+"_input_offset_bytes",
+		"Sum of the filebeat registry offsets of the files collected by an input, it drops when a file rotates away.", inputLabels, nil)
+)
+
+// filebeatStatsCollector polls filebeat's http monitoring endpoint on every
+// scrape and re-exports it, per input progress is read from the registry.
+type filebeatStatsCollector struct {
+	client  *http.Client
+	baseURL string
+	layout  FilebeatLayout
+}
+
+func newFilebeatStatsCollector(host, port string, layout FilebeatLayout) *filebeatStatsCollector {
+	c := &filebeatStatsCollector{
+		client: &http.Client{Timeout: filebeatStatsTimeout},
+		layout: layout,
+	}
+	if strings.HasPrefix(host, "unix://") {
+		socket := strings.TrimPrefix(host, "unix://")
+		c.client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}
+		c.baseURL = "http://unix"
+		return c
+	}
+	if port == "" {
+		port = "5066"
+	}
+	c.baseURL = "http://" + net.JoinHostPort(host, port)
+	return c
+}
+
+func (c *filebeatStatsCollector) get(path string, v interface{}) error {
+	resp, err := c.client.Get(c.baseURL + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (c *filebeatStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- filebeatStatsUpDesc
+	ch <- filebeatHarvestersDesc
+	ch <- filebeatHarvestersTotalDesc
+	ch <- filebeatPipelineEventsDesc
+	ch <- filebeatOutputEventsDesc
+	ch <- filebeatOutputLatencyMedianDesc
+	ch <- filebeatOutputLatencyP95Desc
+	ch <- filebeatOutputLatencyP99Desc
+	ch <- filebeatRegistryStatesDesc
+	ch <- filebeatInputFilesDesc
+	ch <- filebeatInputSizeDesc
+	ch <- filebeatInputOffsetDesc
+}
+
+func (c *filebeatStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	// the registry outlives the process, input progress is reported while filebeat is down
+	c.collectInputs(ch)
+
+	stats := &filebeatStats{}
+	if err := c.get("/stats", stats); err != nil {
+		klog.V(4).Infof("unable to scrape filebeat stats: %v", err)
+		ch <- prometheus.MustNewConstMetric(filebeatStatsUpDesc, prometheus.GaugeValue, 0)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(filebeatStatsUpDesc, prometheus.GaugeValue, 1)
+
+	harvester := stats.Filebeat.Harvester
+	ch <- prometheus.MustNewConstMetric(filebeatHarvestersDesc, prometheus.GaugeValue, harvester.Running, "running")
+	ch <- prometheus.MustNewConstMetric(filebeatHarvestersDesc, prometheus.GaugeValue, harvester.OpenFiles, "open_files")
+	ch <- prometheus.MustNewConstMetric(filebeatHarvestersTotalDesc, prometheus.CounterValue, harvester.Started, "started")
+	ch <- prometheus.MustNewConstMetric(filebeatHarvestersTotalDesc, prometheus.CounterValue, harvester.Closed, "closed")
+
+	pipeline := stats.Libbeat.Pipeline.Events
+	ch <- prometheus.MustNewConstMetric(filebeatPipelineEventsDesc, prometheus.CounterValue, pipeline.Published, "published")
+	ch <- prometheus.MustNewConstMetric(filebeatPipelineEventsDesc, prometheus.CounterValue, pipeline.Failed, "failed")
+	ch <- prometheus.MustNewConstMetric(filebeatPipelineEventsDesc, prometheus.CounterValue, pipeline.Dropped, "dropped")
+	ch <- prometheus.MustNewConstMetric(filebeatPipelineEventsDesc, prometheus.CounterValue, pipeline.Filtered, "filtered")
+
+	output := stats.Libbeat.Output
+	ch <- prometheus.MustNewConstMetric(filebeatOutputEventsDesc, prometheus.CounterValue, output.Events.Acked, "acked")
+	ch <- prometheus.MustNewConstMetric(filebeatOutputEventsDesc, prometheus.CounterValue, output.Events.Failed, "failed")
+	ch <- prometheus.MustNewConstMetric(filebeatOutputEventsDesc, prometheus.CounterValue, output.Events.Dropped, "dropped")
+	latency := output.Write.Latency
+	for desc, v := range map[*prometheus.Desc]*float64{
+		filebeatOutputLatencyMedianDesc: latency.Median,
+		filebeatOutputLatencyP95Desc:    latency.P95,
+		filebeatOutputLatencyP99Desc:    latency.P99,
+	} {
+		if v != nil {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, *v)
+		}
+	}
+
+	ch <- prometheus.MustNewConstMetric(filebeatRegistryStatesDesc, prometheus.GaugeValue, stats.Registrar.States.Current)
+}
+
+// collectInputs reports the progress of every rendered input from the offsets
+// filebeat keeps in its registry.
+func (c *filebeatStatsCollector) collectInputs(ch chan<- prometheus.Metric) {
+	registry, err := c.layout.readRegistry()
+	if err != nil {
+		klog.V(4).Infof("unable to read filebeat registry: %v", err)
+		return
+	}
+	sources := registry.bySource()
+	for _, record := range renderedInputs.snapshot() {
+		for _, input := range record.inputs {
+			labels, ok := parseInputID(input.ID)
+			if !ok {
+				continue
+			}
+			var files, size, offset int64
+			for _, path := range input.collectedFiles(c.layout.FS) {
+				info, err := c.layout.FS.Stat(path)
+				if err != nil || info.IsDir() {
+					continue
+				}
+				files++
+				size += info.Size()
+				offset += registryOffset(sources[path], info)
+			}
+			ch <- prometheus.MustNewConstMetric(filebeatInputFilesDesc, prometheus.GaugeValue, float64(files), labels...)
+			ch <- prometheus.MustNewConstMetric(filebeatInputSizeDesc, prometheus.GaugeValue, float64(size), labels...)
+			ch <- prometheus.MustNewConstMetric(filebeatInputOffsetDesc, prometheus.GaugeValue, float64(offset), labels...)
+		}
+	}
+}
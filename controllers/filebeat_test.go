@@ -0,0 +1,421 @@
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fakeFileSystem keeps the files in memory.
+type fakeFileSystem struct {
+	mu    sync.Mutex
+	files map[string][]byte
+	dirs  map[string]bool
+}
+
+func newFakeFileSystem() *fakeFileSystem {
+	return &fakeFileSystem{files: make(map[string][]byte), dirs: make(map[string]bool)}
+}
+
+func (f *fakeFileSystem) ReadFile(name string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return data, nil
+}
+
+func (f *fakeFileSystem) WriteFile(name string, data []byte, perm os.FileMode) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.dirs[filepath.Dir(name)] {
+		return &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	f.files[name] = append([]byte(nil), data...)
+	return nil
+}
+
+func (f *fakeFileSystem) MkdirAll(path string, perm os.FileMode) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for dir := path; dir != "/" && dir != "."; dir = filepath.Dir(dir) {
+		f.dirs[dir] = true
+	}
+	return nil
+}
+
+func (f *fakeFileSystem) Remove(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.files[name]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(f.files, name)
+	return nil
+}
+
+func (f *fakeFileSystem) Stat(name string) (os.FileInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if data, ok := f.files[name]; ok {
+		return fakeFileInfo{name: filepath.Base(name), size: int64(len(data))}, nil
+	}
+	if f.dirs[name] {
+		return fakeFileInfo{name: filepath.Base(name), dir: true}, nil
+	}
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+func (f *fakeFileSystem) Open(name string) (io.ReadCloser, error) {
+	data, err := f.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (f *fakeFileSystem) Rename(from, to string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.files[from]
+	if !ok {
+		return &fs.PathError{Op: "rename", Path: from, Err: fs.ErrNotExist}
+	}
+	delete(f.files, from)
+	f.files[to] = data
+	return nil
+}
+
+func (f *fakeFileSystem) Truncate(name string, size int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.files[name]
+	if !ok {
+		return &fs.PathError{Op: "truncate", Path: name, Err: fs.ErrNotExist}
+	}
+	if int64(len(data)) > size {
+		f.files[name] = data[:size]
+	}
+	return nil
+}
+
+func (f *fakeFileSystem) Glob(pattern string) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var matches []string
+	for name := range f.files {
+		if ok, err := filepath.Match(pattern, name); err != nil {
+			return nil, err
+		} else if ok {
+			matches = append(matches, name)
+		}
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// Walk visits the files under root in lexical order, directories are not visited.
+func (f *fakeFileSystem) Walk(root string, fn filepath.WalkFunc) error {
+	f.mu.Lock()
+	var names []string
+	for name := range f.files {
+		if strings.HasPrefix(name, root+"/") {
+			names = append(names, name)
+		}
+	}
+	f.mu.Unlock()
+	sort.Strings(names)
+	for _, name := range names {
+		data, err := f.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		if err := fn(name, fakeFileInfo{name: filepath.Base(name), size: int64(len(data))}, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type fakeFileInfo struct {
+	name string
+	size int64
+	dir  bool
+}
+
+func (i fakeFileInfo) Name() string { return i.name }
+func (i fakeFileInfo) Size() int64  { return i.size }
+func (i fakeFileInfo) Mode() os.FileMode {
+	if i.dir {
+		return os.ModeDir | 0755
+	}
+	return 0600
+}
+func (i fakeFileInfo) ModTime() time.Time { return time.Time{} }
+func (i fakeFileInfo) IsDir() bool        { return i.dir }
+func (i fakeFileInfo) Sys() interface{}   { return nil }
+
+// fakeRunner records the processes it starts, they run until signaled.
+type fakeRunner struct {
+	mu        sync.Mutex
+	processes []*fakeProcess
+	started   chan *fakeProcess
+}
+
+func newFakeRunner() *fakeRunner {
+	return &fakeRunner{started: make(chan *fakeProcess, 10)}
+}
+
+func (r *fakeRunner) Start(name string, args ...string) (Process, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	process := &fakeProcess{
+		pid:     1000 + len(r.processes),
+		command: strings.Join(append([]string{name}, args...), " "),
+		exited:  make(chan struct{}),
+	}
+	r.processes = append(r.processes, process)
+	r.started <- process
+	return process, nil
+}
+
+type fakeProcess struct {
+	pid     int
+	command string
+	once    sync.Once
+	exited  chan struct{}
+
+	mu      sync.Mutex
+	signals []os.Signal
+}
+
+func (p *fakeProcess) Pid() int {
+	return p.pid
+}
+
+func (p *fakeProcess) Signal(sig os.Signal) error {
+	p.mu.Lock()
+	p.signals = append(p.signals, sig)
+	p.mu.Unlock()
+	if sig == syscall.SIGTERM {
+		p.once.Do(func() { close(p.exited) })
+	}
+	return nil
+}
+
+func (p *fakeProcess) Kill() error {
+	p.once.Do(func() { close(p.exited) })
+	return nil
+}
+
+func (p *fakeProcess) Wait() error {
+	<-p.exited
+	return fmt.Errorf("signal: terminated")
+}
+
+func (p *fakeProcess) received() []os.Signal {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]os.Signal(nil), p.signals...)
+}
+
+// fakeLayout is a layout on an in-memory file system with a fake runner.
+func fakeLayout() (FilebeatLayout, *fakeFileSystem, *fakeRunner) {
+	fakeFS, runner := newFakeFileSystem(), newFakeRunner()
+	layout := FilebeatLayout{
+		Bin:          "/opt/filebeat/filebeat",
+		Conf:         "/etc/filebeat/filebeat.yml",
+		ConfDir:      "/etc/filebeat/inputs.d",
+		RegistryDir:  "/var/lib/filebeat/registry/filebeat",
+		DiskQueueDir: "/var/lib/filebeat/diskqueue",
+		FS:           fakeFS,
+		Runner:       runner,
+	}
+	return layout, fakeFS, runner
+}
+
+func waitStarted(t *testing.T, runner *fakeRunner) *fakeProcess {
+	t.Helper()
+	select {
+	case process := <-runner.started:
+		return process
+	case <-time.After(5 * time.Second):
+		t.Fatal("filebeat was not started")
+		return nil
+	}
+}
+
+func TestFilebeatLayout(t *testing.T) {
+	layout, fakeFS, runner := fakeLayout()
+
+	ctrl, err := InitFilebeat(layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := fakeFS.ReadFile("/etc/filebeat/filebeat.yml")
+	if err != nil {
+		t.Fatalf("filebeat.yml was not written: %v", err)
+	}
+	if want, _ := filebeatConfigParse(nil); string(config) != want {
+		t.Errorf("filebeat.yml = %q, want the default settings", config)
+	}
+
+	if err := ctrl.StartFilebeat(); err != nil {
+		t.Fatal(err)
+	}
+	first := waitStarted(t, runner)
+	if first.command != "/opt/filebeat/filebeat -c /etc/filebeat/filebeat.yml" {
+		t.Errorf("started %q", first.command)
+	}
+	if status := ctrl.Status(); !status.Running || status.PID != first.pid {
+		t.Errorf("status = %+v, want running with pid %d", status, first.pid)
+	}
+
+	stopped := testutil.ToFloat64(filebeatRestarts.WithLabelValues(restartReasonStopped))
+	exited := testutil.ToFloat64(filebeatRestarts.WithLabelValues(restartReasonExited))
+	ran := false
+	err = ctrl.WithFilebeatStopped(func() error {
+		ran = true
+		if status := ctrl.Status(); status.Running {
+			t.Errorf("filebeat is running while stopped, status = %+v", status)
+		}
+		return nil
+	})
+	if err != nil || !ran {
+		t.Fatalf("WithFilebeatStopped = %v, fn ran %v", err, ran)
+	}
+	if signals := first.received(); len(signals) != 1 || signals[0] != syscall.SIGTERM {
+		t.Errorf("filebeat received %v, want SIGTERM", signals)
+	}
+	second := waitStarted(t, runner)
+	if second.pid == first.pid {
+		t.Error("filebeat was not restarted")
+	}
+	if status := ctrl.Status(); status.Restarts != 1 {
+		t.Errorf("status = %+v, want 1 restart", status)
+	}
+	// a planned restart is not counted as filebeat exiting
+	if got := testutil.ToFloat64(filebeatRestarts.WithLabelValues(restartReasonStopped)) - stopped; got != 1 {
+		t.Errorf("counted %v stopped restarts, want 1", got)
+	}
+	if got := testutil.ToFloat64(filebeatRestarts.WithLabelValues(restartReasonExited)) - exited; got != 0 {
+		t.Errorf("counted %v exited restarts, want 0", got)
+	}
+}
+
+func TestInputConfigFiles(t *testing.T) {
+	layout, fakeFS, _ := fakeLayout()
+
+	file := layout.inputConfigFile("team-a", "demo-0")
+	if file != "/etc/filebeat/inputs.d/team-a_demo-0.yml" {
+		t.Fatalf("inputConfigFile = %s", file)
+	}
+	steps := []struct {
+		name    string
+		write   string
+		remove  bool
+		changed bool
+	}{
+		{name: "create", write: "- type: container\n", changed: true},
+		{name: "unchanged", write: "- type: container\n", changed: false},
+		{name: "update", write: "- type: log\n", changed: true},
+		{name: "remove", remove: true, changed: true},
+		{name: "remove again", remove: true, changed: false},
+	}
+	for _, step := range steps {
+		var changed bool
+		var err error
+		if step.remove {
+			changed, err = layout.RemoveInputConfig(file)
+		} else {
+			changed, err = layout.WriteInputConfig(file, step.write)
+		}
+		if err != nil || changed != step.changed {
+			t.Fatalf("%s: changed %v, %v, want %v", step.name, changed, err, step.changed)
+		}
+		if !step.remove {
+			if data, _ := fakeFS.ReadFile(file); string(data) != step.write {
+				t.Errorf("%s: file holds %q", step.name, data)
+			}
+		}
+	}
+}
+
+func TestWithFilebeatStoppedUnsupervised(t *testing.T) {
+	layout, _, _ := fakeLayout()
+
+	ctrl, err := InitFilebeat(layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ran := false
+	err = ctrl.WithFilebeatStopped(func() error {
+		ran = true
+		return nil
+	})
+	if err != ErrFilebeatNotSupervised || ran {
+		t.Errorf("WithFilebeatStopped = %v, fn ran %v, want ErrFilebeatNotSupervised without running fn", err, ran)
+	}
+}
+
+func TestRegistryCheckpoint(t *testing.T) {
+	layout, fakeFS, _ := fakeLayout()
+	dir := layout.RegistryDir
+	if err := fakeFS.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"active.dat": dir + "/2.json",
+		"2.json":     `[{"_key":"a","source":"/var/log/a.log","offset":10}]`,
+		"log.json": `{"op":"set","id":2}` + "\n" + `{"k":"a","v":{"source":"/var/log/a.log","offset":10}}` + "\n" +
+			`{"op":"set","id":3}` + "\n" + `{"k":"b","v":{"source":"/var/log/b.log","offset":20}}` + "\n",
+	}
+	for name, data := range files {
+		if err := fakeFS.WriteFile(filepath.Join(dir, name), []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	registry, err := layout.readRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if registry.txid != 3 || len(registry.entries) != 2 || registry.entries["b"].Offset != 20 {
+		t.Fatalf("registry at txid %d has %d entries", registry.txid, len(registry.entries))
+	}
+	delete(registry.entries, "a")
+	if err := registry.writeCheckpoint(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fakeFS.ReadFile(filepath.Join(dir, "2.json")); !os.IsNotExist(err) {
+		t.Errorf("the replaced checkpoint was kept: %v", err)
+	}
+	if data, _ := fakeFS.ReadFile(filepath.Join(dir, "log.json")); len(data) != 0 {
+		t.Errorf("log.json was not truncated: %q", data)
+	}
+
+	registry, err = layout.readRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if registry.txid != 3 || len(registry.entries) != 1 || registry.entries["b"] == nil {
+		t.Errorf("registry at txid %d has %v", registry.txid, registry.entries)
+	}
+	if size, err := dirSize(fakeFS, dir); err != nil || size == 0 {
+		t.Errorf("dirSize = %d, %v", size, err)
+	}
+}
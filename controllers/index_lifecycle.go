@@ -0,0 +1,253 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"k8s.io/klog/v2"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	managedIndexPrefix = "kube-log-helper-"
+	// an applied index is only read back this often to find changes made by hand
+	indexDriftCheckInterval = 10 * time.Minute
+	// above the built in logs-*-* templates
+	indexTemplatePriority = 200
+)
+
+// IndexLifecycleManager keeps an index template and a lifecycle policy, both named
+// kube-log-helper-<index>, for every index log sources declaring a retention ship to.
+// The template turns the index into a data stream rolled over and deleted by the
+// policy. Data streams only take create operations, the rendered inputs set
+// @metadata.op_type to create for every index they ship to.
+type IndexLifecycleManager struct {
+	es *ElasticsearchClient
+	sync.Mutex
+	applied map[string]appliedIndex
+}
+
+// errConcreteIndex is returned for an index created before its template, a concrete
+// index cannot become a data stream so its lifecycle is left alone.
+var errConcreteIndex = errors.New("the index exists and is not a data stream, its lifecycle is not managed")
+
+type appliedIndex struct {
+	retention string
+	checked   time.Time
+}
+
+func NewIndexLifecycleManager(es *ElasticsearchClient) *IndexLifecycleManager {
+	return &IndexLifecycleManager{
+		es:      es,
+		applied: make(map[string]appliedIndex),
+	}
+}
+
+// retentionHours orders retentions, an empty one keeps the index forever.
+func retentionHours(retention string) int {
+	if retention == "" {
+		return -1
+	}
+	n, err := strconv.Atoi(retention[:len(retention)-1])
+	if err != nil {
+		return 0
+	}
+	if retention[len(retention)-1] == 'd' {
+		return n * 24
+	}
+	return n
+}
+
+// longerRetention keeps what the longest lived of two WatchLogs sharing an index needs.
+func longerRetention(a, b string) string {
+	if a == "" || b == "" {
+		return ""
+	}
+	if retentionHours(b) > retentionHours(a) {
+		return b
+	}
+	return a
+}
+
+func lifecyclePolicy(retention string) map[string]interface{} {
+	phases := map[string]interface{}{
+		"hot": map[string]interface{}{
+			"min_age": "0ms",
+			"actions": map[string]interface{}{
+				"rollover": map[string]interface{}{"max_primary_shard_size": "50gb", "max_age": "1d"},
+			},
+		},
+	}
+	if retention != "" {
+		phases["delete"] = map[string]interface{}{
+			"min_age": retention,
+			"actions": map[string]interface{}{"delete": map[string]interface{}{}},
+		}
+	}
+	return map[string]interface{}{
+		"_meta":  map[string]interface{}{"managed_by": "kube-log-helper"},
+		"phases": phases,
+	}
+}
+
+func indexTemplate(index, policy string) map[string]interface{} {
+	return map[string]interface{}{
+		"_meta":          map[string]interface{}{"managed_by": "kube-log-helper"},
+		"index_patterns": []interface{}{index},
+		"data_stream":    map[string]interface{}{},
+		"priority":       indexTemplatePriority,
+		"template": map[string]interface{}{
+			"settings": map[string]interface{}{
+				"index": map[string]interface{}{
+					"lifecycle": map[string]interface{}{"name": policy},
+				},
+			},
+		},
+	}
+}
+
+// ensure creates or updates the template and policy of an index, they are only
+// written when missing or different from the desired ones.
+func (m *IndexLifecycleManager) ensure(ctx context.Context, index, retention string) error {
+	m.Lock()
+	applied, ok := m.applied[index]
+	m.Unlock()
+	if ok && applied.retention == retention && time.Since(applied.checked) < indexDriftCheckInterval {
+		return nil
+	}
+
+	concrete, err := m.concreteIndex(ctx, index)
+	if err != nil {
+		return err
+	}
+	if concrete {
+		return errConcreteIndex
+	}
+	name := managedIndexPrefix + index
+	if err := m.ensurePolicy(ctx, name, retention); err != nil {
+		return err
+	}
+	if err := m.ensureTemplate(ctx, name, index); err != nil {
+		return err
+	}
+	m.Lock()
+	m.applied[index] = appliedIndex{retention: retention, checked: time.Now()}
+	m.Unlock()
+	return nil
+}
+
+// concreteIndex is true when an index or an alias, rather than a data stream, has the name.
+func (m *IndexLifecycleManager) concreteIndex(ctx context.Context, index string) (bool, error) {
+	type named struct {
+		Name string `json:"name"`
+	}
+	resolved := struct {
+		Indices []named `json:"indices"`
+		Aliases []named `json:"aliases"`
+	}{}
+	found, err := m.es.get(ctx, "/_resolve/index/"+index, &resolved)
+	if err != nil || !found {
+		return false, err
+	}
+	for _, resolved := range append(resolved.Indices, resolved.Aliases...) {
+		if resolved.Name == index {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *IndexLifecycleManager) ensurePolicy(ctx context.Context, name, retention string) error {
+	path := "/_ilm/policy/" + name
+	desired := normalizeJSON(lifecyclePolicy(retention))
+	existing := map[string]struct {
+		Policy map[string]interface{} `json:"policy"`
+	}{}
+	found, err := m.es.get(ctx, path, &existing)
+	if err != nil {
+		return err
+	}
+	if found {
+		current := existing[name].Policy
+		// a retention removed since leaves a delete phase the subset check accepts
+		phases, _ := current["phases"].(map[string]interface{})
+		if covers(desired, current) && len(phases) == len(desired["phases"].(map[string]interface{})) {
+			return nil
+		}
+		klog.Infof("lifecycle policy %s drifted, updating it", name)
+	}
+	return m.es.put(ctx, path, map[string]interface{}{"policy": desired})
+}
+
+func (m *IndexLifecycleManager) ensureTemplate(ctx context.Context, name, index string) error {
+	path := "/_index_template/" + name
+	desired := normalizeJSON(indexTemplate(index, name))
+	existing := struct {
+		IndexTemplates []struct {
+			Name          string                 `json:"name"`
+			IndexTemplate map[string]interface{} `json:"index_template"`
+		} `json:"index_templates"`
+	}{}
+	found, err := m.es.get(ctx, path, &existing)
+	if err != nil {
+		return err
+	}
+	if found {
+		for _, template := range existing.IndexTemplates {
+			if template.Name == name && covers(desired, template.IndexTemplate) {
+				return nil
+			}
+		}
+		klog.Infof("index template %s drifted, updating it", name)
+	}
+	return m.es.put(ctx, path, desired)
+}
+
+// normalizeJSON gives the desired objects the types of decoded JSON, numbers
+// become float64, to compare them with the ones read back.
+func normalizeJSON(in map[string]interface{}) map[string]interface{} {
+	data, err := json.Marshal(in)
+	if err != nil {
+		panic(fmt.Sprintf("unable to marshal %v: %v", in, err))
+	}
+	out := map[string]interface{}{}
+	if err := json.Unmarshal(data, &out); err != nil {
+		panic(fmt.Sprintf("unable to unmarshal %s: %v", data, err))
+	}
+	return out
+}
+
+// covers is true when every value of desired is set in actual, elasticsearch adds
+// defaults to the objects it stores.
+func covers(desired, actual interface{}) bool {
+	switch d := desired.(type) {
+	case map[string]interface{}:
+		a, ok := actual.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for key, value := range d {
+			if !covers(value, a[key]) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		a, ok := actual.([]interface{})
+		if !ok || len(a) != len(d) {
+			return false
+		}
+		for i := range d {
+			if !covers(d[i], a[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.DeepEqual(desired, actual)
+	}
+}
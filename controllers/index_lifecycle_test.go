@@ -0,0 +1,219 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// elasticsearchStub stores the lifecycle policies and index templates put to it and
+// serves them back the way elasticsearch does, with some defaults added.
+type elasticsearchStub struct {
+	sync.Mutex
+	objects map[string]map[string]interface{}
+	puts    []string
+	fail    bool
+}
+
+func newElasticsearchStub(t *testing.T) (*elasticsearchStub, *httptest.Server) {
+	stub := &elasticsearchStub{objects: make(map[string]map[string]interface{})}
+	server := httptest.NewServer(http.HandlerFunc(stub.serve))
+	t.Cleanup(server.Close)
+	return stub, server
+}
+
+func (s *elasticsearchStub) serve(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	if s.fail {
+		http.Error(w, `{"error":"unavailable"}`, http.StatusServiceUnavailable)
+		return
+	}
+	name := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	switch r.Method {
+	case http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		object := map[string]interface{}{}
+		if err := json.Unmarshal(body, &object); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/_ilm/policy/") {
+			object = object["policy"].(map[string]interface{})
+		}
+		s.objects[r.URL.Path] = object
+		s.puts = append(s.puts, r.URL.Path)
+		w.Write([]byte(`{"acknowledged":true}`))
+	case http.MethodGet:
+		object, ok := s.objects[r.URL.Path]
+		if !ok {
+			http.Error(w, `{"error":"not found"}`, http.StatusNotFound)
+			return
+		}
+		var resp interface{} = object
+		if strings.HasPrefix(r.URL.Path, "/_ilm/policy/") {
+			resp = map[string]interface{}{name: map[string]interface{}{"version": 1, "policy": object}}
+		} else if strings.HasPrefix(r.URL.Path, "/_index_template/") {
+			template := map[string]interface{}{"composed_of": []interface{}{}}
+			for key, value := range object {
+				template[key] = value
+			}
+			resp = map[string]interface{}{"index_templates": []interface{}{
+				map[string]interface{}{"name": name, "index_template": template},
+			}}
+		}
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+func (s *elasticsearchStub) takePuts() []string {
+	s.Lock()
+	defer s.Unlock()
+	puts := s.puts
+	s.puts = nil
+	return puts
+}
+
+// expire forces the next ensure to read the objects back.
+func (m *IndexLifecycleManager) expire() {
+	m.Lock()
+	defer m.Unlock()
+	for index, applied := range m.applied {
+		applied.checked = time.Time{}
+		m.applied[index] = applied
+	}
+}
+
+func TestIndexLifecycleManagerEnsure(t *testing.T) {
+	stub, server := newElasticsearchStub(t)
+	manager := NewIndexLifecycleManager(newElasticsearchClient([]string{server.URL + "/"}, "", ""))
+	ctx := context.Background()
+	policy := "/_ilm/policy/kube-log-helper-app-log"
+	template := "/_index_template/kube-log-helper-app-log"
+
+	if err := manager.ensure(ctx, "app-log", "7d"); err != nil {
+		t.Fatal(err)
+	}
+	if puts := stub.takePuts(); len(puts) != 2 || puts[0] != policy || puts[1] != template {
+		t.Fatalf("expected the policy and template to be created, got %v", puts)
+	}
+
+	// unchanged objects are not written again
+	manager.expire()
+	if err := manager.ensure(ctx, "app-log", "7d"); err != nil {
+		t.Fatal(err)
+	}
+	if puts := stub.takePuts(); len(puts) != 0 {
+		t.Fatalf("expected no write, got %v", puts)
+	}
+
+	// a template changed by hand is put back
+	stub.Lock()
+	stub.objects[template]["priority"] = float64(1)
+	stub.Unlock()
+	if err := manager.ensure(ctx, "app-log", "7d"); err != nil {
+		t.Fatal(err)
+	}
+	if puts := stub.takePuts(); len(puts) != 0 {
+		t.Fatalf("expected the drift check to wait for the interval, got %v", puts)
+	}
+	manager.expire()
+	if err := manager.ensure(ctx, "app-log", "7d"); err != nil {
+		t.Fatal(err)
+	}
+	if puts := stub.takePuts(); len(puts) != 1 || puts[0] != template {
+		t.Fatalf("expected the template to be updated, got %v", puts)
+	}
+
+	// removing the retention removes the delete phase
+	if err := manager.ensure(ctx, "app-log", ""); err != nil {
+		t.Fatal(err)
+	}
+	if puts := stub.takePuts(); len(puts) != 1 || puts[0] != policy {
+		t.Fatalf("expected the policy to be updated, got %v", puts)
+	}
+	phases := stub.objects[policy]["phases"].(map[string]interface{})
+	if _, ok := phases["delete"]; ok {
+		t.Fatalf("expected no delete phase, got %v", phases)
+	}
+
+	stub.Lock()
+	stub.fail = true
+	stub.Unlock()
+	if err := manager.ensure(ctx, "other-log", "1d"); err == nil {
+		t.Fatal("expected an error from an unavailable elasticsearch")
+	}
+}
+
+func TestIndexLifecycleManagerConcreteIndex(t *testing.T) {
+	stub, server := newElasticsearchStub(t)
+	manager := NewIndexLifecycleManager(newElasticsearchClient([]string{server.URL + "/"}, "", ""))
+	stub.objects["/_resolve/index/legacy-log"] = map[string]interface{}{
+		"indices":      []interface{}{map[string]interface{}{"name": "legacy-log"}},
+		"aliases":      []interface{}{},
+		"data_streams": []interface{}{},
+	}
+	if err := manager.ensure(context.Background(), "legacy-log", "7d"); err != errConcreteIndex {
+		t.Fatalf("expected %v, got %v", errConcreteIndex, err)
+	}
+	if puts := stub.takePuts(); len(puts) != 0 {
+		t.Fatalf("expected an existing index to be left alone, got %v", puts)
+	}
+}
+
+func TestLongerRetention(t *testing.T) {
+	tests := []struct {
+		a, b, want string
+	}{
+		{"7d", "30d", "30d"},
+		{"48h", "1d", "48h"},
+		{"7d", "", ""},
+		{"", "1d", ""},
+	}
+	for _, test := range tests {
+		if got := longerRetention(test.a, test.b); got != test.want {
+			t.Errorf("longerRetention(%q, %q) = %q, want %q", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+// managed indices are data streams, the inputs shipping to an index send create operations
+func TestInputsSendCreateOperations(t *testing.T) {
+	for _, index := range []string{"app-log", ""} {
+		config, err := Render(FilebeatInputConfTemplate, Data{
+			"inputConfigList": []*FilebeatInputConfigOptions{{ID: "team-a/demo-0/app/app", Index: index}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		inputs := []struct {
+			Processors []map[string]struct {
+				Target string            `json:"target"`
+				Fields map[string]string `json:"fields"`
+			} `json:"processors"`
+		}{}
+		if err := yaml.Unmarshal([]byte(config), &inputs); err != nil || len(inputs) != 1 {
+			t.Fatalf("unable to parse the inputs: %v\n%s", err, config)
+		}
+		opType := ""
+		for _, processor := range inputs[0].Processors {
+			if fields, ok := processor["add_fields"]; ok && fields.Target == "@metadata" {
+				opType = fields.Fields["op_type"]
+			}
+		}
+		if index != "" && opType != "create" {
+			t.Errorf("input of index %s sends op_type %q, want create:\n%s", index, opType, config)
+		}
+		if index == "" && opType != "" {
+			t.Errorf("input without index sends op_type %q:\n%s", opType, config)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package controllers
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// keeps the index names rendered before naming templates existed
+	defaultIndexTemplate = "{{index}}-log"
+	defaultTopicTemplate = "{{topic}}"
+	// elasticsearch limits index names in bytes, kafka topic names in characters
+	maxIndexNameBytes = 255
+	maxTopicNameChars = 249
+)
+
+var (
+	namingVariable = regexp.MustCompile(`{{\s*([a-z_]*)\s*}}`)
+	// the declared index and topic, or the source name when none is declared
+	namingVariables = map[string]bool{
+		"cluster": true, "namespace": true, "workload": true, "container": true,
+		"source": true, "index": true, "topic": true,
+	}
+	invalidIndexChars = regexp.MustCompile(`[\\/*?"<>| ,#:]+`)
+	invalidTopicChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+	// the enforced prefix only takes the names kubernetes validates, none of them
+	// contains _, so the _ ending each of them keeps a source from posing as another
+	// namespace: namespace a with index b-x could otherwise land in the indices of a-b
+	prefixVariables = map[string]bool{"cluster": true, "namespace": true, "workload": true, "container": true}
+	prefixSeparator = "_"
+)
+
+// IndexNaming renders the index and topic of every log source from the templates
+// set by the cluster admins, e.g. "{{cluster}}-{{namespace}}_{{index}}-log". A source
+// can only choose the {{index}} and {{topic}} parts, the enforced prefix keeps teams
+// from writing into each other's indices.
+type IndexNaming struct {
+	index   string
+	topic   string
+	prefix  string
+	cluster string
+}
+
+func indexNamingInit() (*IndexNaming, error) {
+	// e.g: LOGGING_INDEX_TEMPLATE="{{cluster}}-{{index}}-log" LOGGING_INDEX_ENFORCED_PREFIX="{{namespace}}_"
+	naming := &IndexNaming{
+		index:   defaultIndexTemplate,
+		topic:   defaultTopicTemplate,
+		prefix:  os.Getenv(EnvLoggingIndexEnforcedPrefix),
+		cluster: os.Getenv(EnvClusterEnvName),
+	}
+	if v := os.Getenv(EnvLoggingIndexTemplate); v != "" {
+		naming.index = v
+	}
+	if v := os.Getenv(EnvLoggingTopicTemplate); v != "" {
+		naming.topic = v
+	}
+	for env, tmpl := range map[string]string{
+		EnvLoggingIndexTemplate:       naming.index,
+		EnvLoggingTopicTemplate:       naming.topic,
+		EnvLoggingIndexEnforcedPrefix: naming.prefix,
+	} {
+		if err := validateNamingTemplate(tmpl); err != nil {
+			return nil, fmt.Errorf("%s: %v", env, err)
+		}
+	}
+	if err := validateEnforcedPrefix(naming.prefix); err != nil {
+		return nil, fmt.Errorf("%s: %v", EnvLoggingIndexEnforcedPrefix, err)
+	}
+	return naming, nil
+}
+
+// validateEnforcedPrefix checks every variable of the prefix but the cluster is a
+// kubernetes name followed by the separator, e.g. "{{cluster}}-{{namespace}}_".
+func validateEnforcedPrefix(tmpl string) error {
+	for _, loc := range namingVariable.FindAllStringSubmatchIndex(tmpl, -1) {
+		name := tmpl[loc[2]:loc[3]]
+		if !prefixVariables[name] {
+			return fmt.Errorf("%q is chosen by the log sources, it cannot enforce a prefix", tmpl[loc[0]:loc[1]])
+		}
+		if name != "cluster" && !strings.HasPrefix(tmpl[loc[1]:], prefixSeparator) {
+			return fmt.Errorf("%q must be followed by %q, a character the names of kubernetes objects cannot contain", tmpl[loc[0]:loc[1]], prefixSeparator)
+		}
+	}
+	return nil
+}
+
+func validateNamingTemplate(tmpl string) error {
+	for _, match := range namingVariable.FindAllStringSubmatch(tmpl, -1) {
+		if !namingVariables[match[1]] {
+			return fmt.Errorf("unknown variable %q in %q", match[0], tmpl)
+		}
+	}
+	if strings.Contains(namingVariable.ReplaceAllString(tmpl, ""), "{{") {
+		return fmt.Errorf("invalid template %q", tmpl)
+	}
+	return nil
+}
+
+func renderNamingTemplate(tmpl string, vars map[string]string) string {
+	return namingVariable.ReplaceAllStringFunc(tmpl, func(s string) string {
+		return vars[namingVariable.FindStringSubmatch(s)[1]]
+	})
+}
+
+// names returns the index and the topic a log source ships to.
+func (n *IndexNaming) names(vars map[string]string) (string, string) {
+	if n == nil {
+		n = &IndexNaming{index: defaultIndexTemplate, topic: defaultTopicTemplate}
+	}
+	vars["cluster"] = n.cluster
+	prefix := renderNamingTemplate(n.prefix, vars)
+	index := withPrefix(sanitizeIndexName(prefix), sanitizeIndexName(renderNamingTemplate(n.index, vars)))
+	topic := withPrefix(sanitizeTopicName(prefix), sanitizeTopicName(renderNamingTemplate(n.topic, vars)))
+	return truncateName(index, maxIndexNameBytes), truncateName(topic, maxTopicNameChars)
+}
+
+func withPrefix(prefix, name string) string {
+	if strings.HasPrefix(name, prefix) {
+		return name
+	}
+	return prefix + name
+}
+
+// sanitizeIndexName follows the elasticsearch index name rules: lowercase, none of
+// \/*?"<>| ,#: and no leading -, _ or +.
+func sanitizeIndexName(name string) string {
+	name = invalidIndexChars.ReplaceAllString(strings.ToLower(name), "-")
+	name = strings.TrimLeft(name, "-_+")
+	if name == "." || name == ".." {
+		return ""
+	}
+	return name
+}
+
+// sanitizeTopicName follows the kafka topic name rules, unlike indices topics keep their case.
+func sanitizeTopicName(name string) string {
+	name = invalidTopicChars.ReplaceAllString(name, "-")
+	if name == "." || name == ".." {
+		return ""
+	}
+	return name
+}
+
+// truncateName cuts the name to max bytes without splitting a unicode letter,
+// elasticsearch accepts them in index names.
+func truncateName(name string, max int) string {
+	if len(name) <= max {
+		return name
+	}
+	name = name[:max]
+	for !utf8.ValidString(name) {
+		name = name[:len(name)-1]
+	}
+	return strings.TrimRight(name, "-")
+}
@@ -0,0 +1,56 @@
+package controllers
+
+import (
+	"testing"
+)
+
+func TestIndexNamingNames(t *testing.T) {
+	naming := &IndexNaming{
+		index:   "{{cluster}}-{{namespace}}_{{index}}-log",
+		topic:   "{{topic}}",
+		prefix:  "{{cluster}}-{{namespace}}_",
+		cluster: "prod",
+	}
+	tests := []struct {
+		name      string
+		namespace string
+		index     string
+		topic     string
+		wantIndex string
+		wantTopic string
+	}{
+		{name: "templates", namespace: "a", index: "b", topic: "b", wantIndex: "prod-a_b-log", wantTopic: "prod-a_b"},
+		{name: "topic keeps its case", namespace: "a", index: "b", topic: "Orders.V1", wantIndex: "prod-a_b-log", wantTopic: "prod-a_Orders.V1"},
+		{name: "invalid characters", namespace: "a", index: "B/x", topic: "b/x", wantIndex: "prod-a_b-x-log", wantTopic: "prod-a_b-x"},
+		// namespace a cannot write into the indices of namespace a-b
+		{name: "dash in the index", namespace: "a", index: "b-x", topic: "b-x", wantIndex: "prod-a_b-x-log", wantTopic: "prod-a_b-x"},
+		{name: "dash in the namespace", namespace: "a-b", index: "x", topic: "x", wantIndex: "prod-a-b_x-log", wantTopic: "prod-a-b_x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			index, topic := naming.names(map[string]string{"namespace": tt.namespace, "index": tt.index, "topic": tt.topic})
+			if index != tt.wantIndex || topic != tt.wantTopic {
+				t.Errorf("names() = %q, %q, want %q, %q", index, topic, tt.wantIndex, tt.wantTopic)
+			}
+		})
+	}
+}
+
+func TestValidateEnforcedPrefix(t *testing.T) {
+	tests := []struct {
+		prefix  string
+		wantErr bool
+	}{
+		{prefix: ""},
+		{prefix: "{{cluster}}-{{namespace}}_"},
+		{prefix: "{{namespace}}_{{workload}}_"},
+		{prefix: "{{namespace}}-", wantErr: true},
+		{prefix: "{{namespace}}", wantErr: true},
+		{prefix: "{{index}}_", wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := validateEnforcedPrefix(tt.prefix); (err != nil) != tt.wantErr {
+			t.Errorf("validateEnforcedPrefix(%q) error = %v, wantErr %v", tt.prefix, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package controllers
+
+import (
+	"strings"
+	"sync"
+)
+
+// inputRecord is the last rendered content of one pod input file.
+type inputRecord struct {
+	file      string
+	namespace string
+	podName   string
+	inputs    []*FilebeatInputConfigOptions
+	// sha256 of the rendered file
+	hash string
+	// names of the WatchLogs selecting the pod
+	watchLogs []string
+}
+
+// inputStore keeps the inputs rendered on this node keyed by input file.
+type inputStore struct {
+	sync.RWMutex
+	records map[string]*inputRecord
+}
+
+var renderedInputs = &inputStore{
+	records: make(map[string]*inputRecord),
+}
+
+func (s *inputStore) set(record *inputRecord) {
+	s.Lock()
+	defer s.Unlock()
+	if old, ok := s.records[record.file]; ok {
+		old.observe(-1)
+	}
+	s.records[record.file] = record
+	record.observe(1)
+}
+
+func (s *inputStore) snapshot() []*inputRecord {
+	s.RLock()
+	defer s.RUnlock()
+	records := make([]*inputRecord, 0, len(s.records))
+	for _, record := range s.records {
+		records = append(records, record)
+	}
+	return records
+}
+
+func (s *inputStore) delete(file string) {
+	s.Lock()
+	defer s.Unlock()
+	if old, ok := s.records[file]; ok {
+		old.observe(-1)
+		delete(s.records, file)
+	}
+}
+
+func (r *inputRecord) observe(delta float64) {
+	for _, input := range r.inputs {
+		format := input.Format
+		// regexp formats are rendered as /pattern/
+		if strings.HasPrefix(format, "/") {
+			format = "regexp"
+		}
+		activeInputs.WithLabelValues(r.namespace, format).Add(delta)
+	}
+}
@@ -0,0 +1,179 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Shopify/sarama"
+	"k8s.io/klog/v2"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTopicPartitions        = 3
+	defaultTopicReplicationFactor = 3
+	// topics created by hand or by other tools are picked up this often
+	topicListInterval = 5 * time.Minute
+)
+
+var retentionPattern = regexp.MustCompile(`^[0-9]+[dh]$`)
+
+// TopicAdmin is the part of the kafka admin API the TopicProvisioner uses,
+// implemented by sarama.ClusterAdmin.
+type TopicAdmin interface {
+	ListTopics() (map[string]sarama.TopicDetail, error)
+	CreateTopic(topic string, detail *sarama.TopicDetail, validateOnly bool) error
+	Close() error
+}
+
+// TopicProvisioner creates the kafka topics log sources ship to when they first
+// appear, with the partitions, replication and retention set by the cluster
+// admins. Only topics matching the allowlist are created.
+type TopicProvisioner struct {
+	newAdmin  func() (TopicAdmin, error)
+	detail    sarama.TopicDetail
+	allowlist []*regexp.Regexp
+
+	sync.Mutex
+	admin  TopicAdmin
+	topics map[string]bool
+	listed time.Time
+}
+
+// NewTopicProvisioner returns nil when KAFKA_BROKERS is not set.
+func NewTopicProvisioner() (*TopicProvisioner, error) {
+	// e.g: KAFKA_BROKERS="kafka-0:9092,kafka-1:9092" KAFKA_TOPIC_ALLOWLIST="^team-.*-log$"
+	brokers := os.Getenv(EnvKafkaBrokers)
+	if brokers == "" {
+		return nil, nil
+	}
+	config := sarama.NewConfig()
+	config.ClientID = "kube-log-helper"
+	config.Version = sarama.V1_0_0_0
+	if v := os.Getenv(EnvKafkaVersion); v != "" {
+		version, err := sarama.ParseKafkaVersion(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", EnvKafkaVersion, err)
+		}
+		config.Version = version
+	}
+	return newTopicProvisioner(func() (TopicAdmin, error) {
+		return sarama.NewClusterAdmin(strings.Split(brokers, ","), config)
+	})
+}
+
+func newTopicProvisioner(newAdmin func() (TopicAdmin, error)) (*TopicProvisioner, error) {
+	p := &TopicProvisioner{
+		newAdmin: newAdmin,
+		detail: sarama.TopicDetail{
+			NumPartitions:     defaultTopicPartitions,
+			ReplicationFactor: defaultTopicReplicationFactor,
+		},
+		topics: make(map[string]bool),
+	}
+	if v := os.Getenv(EnvKafkaTopicPartitions); v != "" {
+		n, err := strconv.ParseInt(v, 10, 32)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid %s %q, expected a number of partitions", EnvKafkaTopicPartitions, v)
+		}
+		p.detail.NumPartitions = int32(n)
+	}
+	if v := os.Getenv(EnvKafkaTopicReplicationFactor); v != "" {
+		n, err := strconv.ParseInt(v, 10, 16)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid %s %q, expected a number of replicas", EnvKafkaTopicReplicationFactor, v)
+		}
+		p.detail.ReplicationFactor = int16(n)
+	}
+	if v := os.Getenv(EnvKafkaTopicRetention); v != "" {
+		if !retentionPattern.MatchString(v) {
+			return nil, fmt.Errorf("invalid %s %q, expected e.g. 7d or 36h", EnvKafkaTopicRetention, v)
+		}
+		ms := strconv.FormatInt(int64(retentionHours(v))*time.Hour.Milliseconds(), 10)
+		p.detail.ConfigEntries = map[string]*string{"retention.ms": &ms}
+	}
+	if v := os.Getenv(EnvKafkaTopicAllowlist); v != "" {
+		for _, pattern := range strings.Split(v, ",") {
+			re, err := regexp.Compile(strings.TrimSpace(pattern))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", EnvKafkaTopicAllowlist, err)
+			}
+			p.allowlist = append(p.allowlist, re)
+		}
+	}
+	return p, nil
+}
+
+// allowed is true when the topic matches the allowlist, every topic is allowed
+// without one.
+func (p *TopicProvisioner) allowed(topic string) bool {
+	for _, re := range p.allowlist {
+		if re.MatchString(topic) {
+			return true
+		}
+	}
+	return len(p.allowlist) == 0
+}
+
+// ensure creates the topic unless it exists, the allowlist only applies to the topics
+// created, not to the ones already there.
+func (p *TopicProvisioner) ensure(topic string) error {
+	p.Lock()
+	defer p.Unlock()
+	if p.admin == nil {
+		admin, err := p.newAdmin()
+		if err != nil {
+			return err
+		}
+		p.admin = admin
+	}
+	if p.topics[topic] {
+		return nil
+	}
+	if time.Since(p.listed) > topicListInterval {
+		topics, err := p.admin.ListTopics()
+		if err != nil {
+			p.reset()
+			return err
+		}
+		p.topics = make(map[string]bool, len(topics))
+		for name := range topics {
+			p.topics[name] = true
+		}
+		p.listed = time.Now()
+		if p.topics[topic] {
+			return nil
+		}
+	}
+	if !p.allowed(topic) {
+		return fmt.Errorf("topic %s is not allowed by %s", topic, EnvKafkaTopicAllowlist)
+	}
+
+	detail := p.detail
+	err := p.admin.CreateTopic(topic, &detail, false)
+	var topicErr *sarama.TopicError
+	if err != nil && !(errors.As(err, &topicErr) && topicErr.Err == sarama.ErrTopicAlreadyExists) {
+		if topicErr == nil {
+			p.reset()
+		}
+		return err
+	}
+	if err == nil {
+		klog.Infof("created kafka topic %s with %d partitions and %d replicas", topic, detail.NumPartitions, detail.ReplicationFactor)
+	}
+	p.topics[topic] = true
+	return nil
+}
+
+// reset drops the admin client after a broker error, the next call connects again.
+func (p *TopicProvisioner) reset() {
+	if err := p.admin.Close(); err != nil {
+		klog.V(4).Infof("unable to close kafka admin client: %v", err)
+	}
+	p.admin = nil
+	p.listed = time.Time{}
+}
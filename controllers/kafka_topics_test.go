@@ -0,0 +1,154 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	crdk8sv1alpha1 "github.com/cccfs/kube-log-helper/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// fakeTopicAdmin keeps the topics in memory.
+type fakeTopicAdmin struct {
+	topics  map[string]sarama.TopicDetail
+	created []string
+	lists   int
+	fail    error
+	closed  bool
+}
+
+func (a *fakeTopicAdmin) ListTopics() (map[string]sarama.TopicDetail, error) {
+	a.lists++
+	if a.fail != nil {
+		return nil, a.fail
+	}
+	topics := make(map[string]sarama.TopicDetail, len(a.topics))
+	for name, detail := range a.topics {
+		topics[name] = detail
+	}
+	return topics, nil
+}
+
+func (a *fakeTopicAdmin) CreateTopic(topic string, detail *sarama.TopicDetail, validateOnly bool) error {
+	if _, ok := a.topics[topic]; ok {
+		return &sarama.TopicError{Err: sarama.ErrTopicAlreadyExists}
+	}
+	a.topics[topic] = *detail
+	a.created = append(a.created, topic)
+	return nil
+}
+
+func (a *fakeTopicAdmin) Close() error {
+	a.closed = true
+	return nil
+}
+
+func TestTopicProvisionerEnsure(t *testing.T) {
+	t.Setenv(EnvKafkaTopicAllowlist, "^team-")
+	t.Setenv(EnvKafkaTopicPartitions, "6")
+	t.Setenv(EnvKafkaTopicRetention, "1d")
+	admin := &fakeTopicAdmin{topics: map[string]sarama.TopicDetail{"legacy": {}}}
+	provisioner, err := newTopicProvisioner(func() (TopicAdmin, error) {
+		return admin, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := provisioner.ensure("team-a"); err != nil {
+		t.Fatal(err)
+	}
+	detail := admin.topics["team-a"]
+	if detail.NumPartitions != 6 || detail.ReplicationFactor != defaultTopicReplicationFactor {
+		t.Errorf("created with %d partitions and %d replicas", detail.NumPartitions, detail.ReplicationFactor)
+	}
+	if retention := detail.ConfigEntries["retention.ms"]; retention == nil || *retention != "86400000" {
+		t.Errorf("created with retention.ms %v", retention)
+	}
+
+	// existing topics are ready whatever the allowlist says
+	if err := provisioner.ensure("legacy"); err != nil {
+		t.Errorf("existing topic: %v", err)
+	}
+	if err := provisioner.ensure("other"); err == nil {
+		t.Error("a topic outside the allowlist was created")
+	}
+	if err := provisioner.ensure("team-a"); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"team-a"}; !reflect.DeepEqual(admin.created, want) {
+		t.Errorf("created %v, want %v", admin.created, want)
+	}
+	if admin.lists != 1 {
+		t.Errorf("listed the topics %d times, want once per interval", admin.lists)
+	}
+
+	// a broker error drops the client so the next call connects again
+	provisioner.listed = provisioner.listed.Add(-2 * topicListInterval)
+	admin.fail = errors.New("broker down")
+	if err := provisioner.ensure("team-b"); err == nil {
+		t.Fatal("expected the broker error")
+	}
+	if !admin.closed || provisioner.admin != nil {
+		t.Error("the admin client was kept after a broker error")
+	}
+}
+
+func TestPodTopicReconciler(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := crdk8sv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	pod := testPod(corev1.Container{
+		Name:         "app",
+		VolumeMounts: []corev1.VolumeMount{{Name: "logs", MountPath: "/var/log/app"}},
+	})
+	pod.Labels = map[string]string{"app": "demo"}
+	watchLog := &crdk8sv1alpha1.WatchLog{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: pod.Namespace},
+		Spec: crdk8sv1alpha1.WatchLogSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "demo"}},
+			Sources:  []crdk8sv1alpha1.LogSource{{Name: "audit", Output: "/var/log/app/audit.log"}},
+		},
+	}
+	admin := &fakeTopicAdmin{topics: map[string]sarama.TopicDetail{}, fail: errors.New("broker down")}
+	provisioner, err := newTopicProvisioner(func() (TopicAdmin, error) {
+		return admin, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &PodTopicReconciler{
+		Client:   fake.NewClientBuilder().WithScheme(scheme).WithObjects(pod, watchLog).Build(),
+		Recorder: record.NewFakeRecorder(10),
+		Topics:   provisioner,
+	}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)}
+
+	// a broker error is retried, the pod may not change again
+	result, err := r.Reconcile(context.Background(), req)
+	if err != nil || result.RequeueAfter == 0 {
+		t.Fatalf("Reconcile = %+v, %v, want a retry", result, err)
+	}
+	admin.fail = nil
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	// the sources of the WatchLogs selecting the pod are provisioned
+	if want := []string{"audit"}; !reflect.DeepEqual(admin.created, want) {
+		t.Errorf("created %v, want %v", admin.created, want)
+	}
+}
@@ -6,10 +6,15 @@ type FormatConverter func(info *LogInfoNode) (map[string]string, error)
 
 var converters = make(map[string]FormatConverter)
 
+// multilinePresets maps prefix_logs_xxx_multiline values to the multiline.pattern of the input.
+var multilinePresets = map[string]string{
+	"java": `^\d{4}-\d{2}-\d{2}\s\d{2}:\d{2}:\d{2}\d*`,
+}
+
 func Convert(info *LogInfoNode) (map[string]string, error) {
 	converter := converters[info.value]
 	if converter == nil {
-		return nil, fmt.Errorf("unsupported log format: %s", info.value)
+		return nil, &UnsupportedFormatError{Format: info.value}
 	}
 	return converter(info)
 }
@@ -0,0 +1,73 @@
+package controllers
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		name       string
+		format     string
+		properties map[string]string
+		want       map[string]string
+		wantErr    bool
+	}{
+		{name: "none", format: "none", want: map[string]string{}},
+		{name: "nginx", format: "nginx", want: map[string]string{}},
+		{name: "apache2", format: "apache2", want: map[string]string{}},
+		{name: "apache_error", format: "apache_error", want: map[string]string{}},
+		{
+			name:       "json",
+			format:     "json",
+			properties: map[string]string{"time_key": "ts", "time_format": "%Y-%m-%d"},
+			want:       map[string]string{"time_key": "ts", "time_format": "%Y-%m-%d"},
+		},
+		{
+			name:       "csv",
+			format:     "csv",
+			properties: map[string]string{"keys": "time,level,message"},
+			want:       map[string]string{"keys": "time,level,message"},
+		},
+		{
+			name:       "regexp",
+			format:     "regexp",
+			properties: map[string]string{"pattern": `^(?P<level>\w+) (?P<message>.*)$`},
+			want:       map[string]string{"pattern": `^(?P<level>\w+) (?P<message>.*)$`},
+		},
+		{name: "regexp without pattern", format: "regexp", wantErr: true},
+		{
+			name:       "regexp time_key",
+			format:     "regexp",
+			properties: map[string]string{"pattern": ".*", "time_key": "ts"},
+			wantErr:    true,
+		},
+		{name: "nginx with a property", format: "nginx", properties: map[string]string{"keys": "a"}, wantErr: true},
+		{name: "json with an unknown property", format: "json", properties: map[string]string{"keys": "a"}, wantErr: true},
+		{name: "unknown format", format: "logfmt", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			info := newLogInfoNode(test.format)
+			for key, value := range test.properties {
+				info.children[key] = newLogInfoNode(value)
+			}
+			got, err := Convert(info)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Convert(%s) error = %v, want error %v", test.format, err, test.wantErr)
+			}
+			if !test.wantErr && !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Convert(%s) = %v, want %v", test.format, got, test.want)
+			}
+		})
+	}
+}
+
+func TestConvertUnsupportedFormat(t *testing.T) {
+	_, err := Convert(newLogInfoNode("logfmt"))
+	var unsupported *UnsupportedFormatError
+	if !errors.As(err, &unsupported) || unsupported.Format != "logfmt" {
+		t.Errorf("Convert(logfmt) error = %v, want an UnsupportedFormatError", err)
+	}
+}
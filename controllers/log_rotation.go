@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// rotation schemes of log files inside volumes, declared with k8s_logs_<name>_rotation
+const (
+	// app.log is renamed to app.log.1 and a new app.log is created
+	RotationRename = "rename"
+	// app.log is copied to app.log.1 and truncated, it keeps its inode
+	RotationCopyTruncate = "copytruncate"
+	// app.log is renamed to app.log.2022-01-01 or app.2022-01-01.log
+	RotationDated = "dated"
+)
+
+var (
+	rotationSchemes = map[string]bool{RotationRename: true, RotationCopyTruncate: true, RotationDated: true}
+
+	// filebeat cannot read compressed rotations
+	compressedFiles = `\.gz$`
+	// copies left by copytruncate, app.log.1 or app.log.2022-01-01
+	rotatedCopies = []string{`\.[0-9]+$`, `[-._][0-9]{4}-?[0-9]{2}-?[0-9]{2}[^/]*$`}
+	// the glob of a dated rotation suffix, a separator then a year
+	datedSuffix = `[\-._][0-9][0-9][0-9][0-9]*`
+)
+
+func (node *LogInfoNode) parseRotation() (string, error) {
+	// prefix_logs_xxx_rotation: "rename|copytruncate|dated"
+	rotation := strings.TrimSpace(node.get("rotation"))
+	if rotation != "" && !rotationSchemes[rotation] {
+		return "", fmt.Errorf("unknown rotation %q, expected rename, copytruncate or dated", rotation)
+	}
+	return rotation, nil
+}
+
+// Paths are the globs of the input. Filebeat follows a file by its inode, a renamed
+// rotation keeps the state of the live file it was and its unread end is still
+// shipped, so renamed rotations are collected. A copytruncate rotation is a new
+// file holding lines already shipped, only the live file is collected.
+func (o *FilebeatInputConfigOptions) Paths() []string {
+	live := filepath.Join(o.HostDir, o.File)
+	if o.Stdout {
+		return []string{live}
+	}
+	switch o.Rotation {
+	case RotationRename:
+		return []string{live, live + ".*"}
+	case RotationDated:
+		paths := []string{live, live + datedSuffix}
+		if ext := filepath.Ext(o.File); ext != "" && ext != o.File {
+			// app.log rotated to app.2022-01-01.log or app-2022-01-01.log, not app-access.log
+			paths = append(paths, filepath.Join(o.HostDir, strings.TrimSuffix(o.File, ext)+datedSuffix+ext))
+		}
+		return paths
+	default:
+		return []string{live}
+	}
+}
+
+// ExcludeFiles are the exclude_files regexps of the input, matched against the
+// path of every file the globs of Paths find.
+func (o *FilebeatInputConfigOptions) ExcludeFiles() []string {
+	if o.Stdout {
+		return nil
+	}
+	exclude := []string{compressedFiles}
+	if o.Rotation == RotationCopyTruncate {
+		exclude = append(exclude, rotatedCopies...)
+	}
+	return exclude
+}
+
+// collectedFiles returns the files the input harvests, as filebeat resolves Paths
+// and ExcludeFiles.
+func (o *FilebeatInputConfigOptions) collectedFiles(fsys FileSystem) []string {
+	exclude := make([]*regexp.Regexp, 0)
+	for _, pattern := range o.ExcludeFiles() {
+		exclude = append(exclude, regexp.MustCompile(pattern))
+	}
+	seen := make(map[string]bool)
+	files := make([]string, 0)
+	for _, pattern := range o.Paths() {
+		matches, _ := fsys.Glob(pattern)
+		for _, path := range matches {
+			if seen[path] || matchesAnyPattern(exclude, path) {
+				continue
+			}
+			seen[path] = true
+			files = append(files, path)
+		}
+	}
+	return files
+}
+
+func matchesAnyPattern(patterns []*regexp.Regexp, path string) bool {
+	for _, re := range patterns {
+		if re.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,224 @@
+package controllers
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func writeLog(t *testing.T, path, content string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func gzipFile(t *testing.T, path string) {
+	t.Helper()
+	in, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	out, err := os.Create(path + ".gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	w := gzip.NewWriter(out)
+	if _, err := io.Copy(w, in); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func copyTruncate(t *testing.T, path, rotated string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(rotated, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func rename(t *testing.T, from, to string) {
+	t.Helper()
+	if err := os.Rename(from, to); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLogRotation(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		rotation string
+		// rotate simulates the rotations, the live file app.log exists before
+		rotate func(t *testing.T, dir string)
+		// globs and exclude_files rendered for the input, relative to the log directory
+		paths        []string
+		excludeFiles []string
+		// files harvested after the rotations
+		collected []string
+	}{
+		{
+			name:     "rename",
+			file:     "app.log",
+			rotation: RotationRename,
+			rotate: func(t *testing.T, dir string) {
+				rename(t, filepath.Join(dir, "app.log"), filepath.Join(dir, "app.log.1"))
+				writeLog(t, filepath.Join(dir, "app.log"), "after first rotation\n")
+				rename(t, filepath.Join(dir, "app.log.1"), filepath.Join(dir, "app.log.2"))
+				gzipFile(t, filepath.Join(dir, "app.log.2"))
+				rename(t, filepath.Join(dir, "app.log"), filepath.Join(dir, "app.log.1"))
+				writeLog(t, filepath.Join(dir, "app.log"), "after second rotation\n")
+			},
+			paths:        []string{"app.log", "app.log.*"},
+			excludeFiles: []string{compressedFiles},
+			collected:    []string{"app.log", "app.log.1"},
+		},
+		{
+			name:     "rename keeps the state of the live file",
+			file:     "app.log",
+			rotation: RotationRename,
+			rotate: func(t *testing.T, dir string) {
+				rename(t, filepath.Join(dir, "app.log"), filepath.Join(dir, "app.log.1"))
+				writeLog(t, filepath.Join(dir, "app.log"), "after rotation\n")
+			},
+			// the rotation keeps the inode, and the state, of the live file it was
+			paths:        []string{"app.log", "app.log.*"},
+			excludeFiles: []string{compressedFiles},
+			collected:    []string{"app.log", "app.log.1"},
+		},
+		{
+			name:     "copytruncate",
+			file:     "app.log",
+			rotation: RotationCopyTruncate,
+			rotate: func(t *testing.T, dir string) {
+				copyTruncate(t, filepath.Join(dir, "app.log"), filepath.Join(dir, "app.log.1"))
+				writeLog(t, filepath.Join(dir, "app.log"), "after rotation\n")
+				copyTruncate(t, filepath.Join(dir, "app.log"), filepath.Join(dir, "app.log.2022-01-01"))
+				gzipFile(t, filepath.Join(dir, "app.log.1"))
+			},
+			// the copies hold lines already shipped from the live file
+			paths:        []string{"app.log"},
+			excludeFiles: append([]string{compressedFiles}, rotatedCopies...),
+			collected:    []string{"app.log"},
+		},
+		{
+			name:     "copytruncate with a glob",
+			file:     "*.log*",
+			rotation: RotationCopyTruncate,
+			rotate: func(t *testing.T, dir string) {
+				copyTruncate(t, filepath.Join(dir, "app.log"), filepath.Join(dir, "app.log.1"))
+				copyTruncate(t, filepath.Join(dir, "app.log"), filepath.Join(dir, "app.log-20220101"))
+			},
+			paths:        []string{"*.log*"},
+			excludeFiles: append([]string{compressedFiles}, rotatedCopies...),
+			collected:    []string{"app.log"},
+		},
+		{
+			name:     "dated suffix",
+			file:     "app.log",
+			rotation: RotationDated,
+			rotate: func(t *testing.T, dir string) {
+				rename(t, filepath.Join(dir, "app.log"), filepath.Join(dir, "app.log.2022-01-01"))
+				writeLog(t, filepath.Join(dir, "app.log"), "day 2\n")
+				rename(t, filepath.Join(dir, "app.log"), filepath.Join(dir, "app.2022-01-02.log"))
+				writeLog(t, filepath.Join(dir, "app.log"), "day 3\n")
+				gzipFile(t, filepath.Join(dir, "app.log.2022-01-01"))
+				writeLog(t, filepath.Join(dir, "other.log"), "another file\n")
+				// other logs of the application are not rotations of app.log
+				writeLog(t, filepath.Join(dir, "app-access.log"), "another file\n")
+				writeLog(t, filepath.Join(dir, "app_error.log"), "another file\n")
+				writeLog(t, filepath.Join(dir, "app.log.bak"), "another file\n")
+			},
+			paths:        []string{"app.log", "app.log" + datedSuffix, "app" + datedSuffix + ".log"},
+			excludeFiles: []string{compressedFiles},
+			collected:    []string{"app.2022-01-02.log", "app.log"},
+		},
+		{
+			name: "no rotation declared skips compressed files",
+			file: "*.log*",
+			rotate: func(t *testing.T, dir string) {
+				rename(t, filepath.Join(dir, "app.log"), filepath.Join(dir, "app.log.1"))
+				gzipFile(t, filepath.Join(dir, "app.log.1"))
+				writeLog(t, filepath.Join(dir, "app.log"), "after rotation\n")
+			},
+			paths:        []string{"*.log*"},
+			excludeFiles: []string{compressedFiles},
+			collected:    []string{"app.log"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeLog(t, filepath.Join(dir, "app.log"), "before rotation\n")
+			test.rotate(t, dir)
+
+			input := &FilebeatInputConfigOptions{HostDir: dir, File: test.file, Rotation: test.rotation}
+			paths := make([]string, 0)
+			for _, path := range input.Paths() {
+				paths = append(paths, strings.TrimPrefix(path, dir+"/"))
+			}
+			if !reflect.DeepEqual(paths, test.paths) {
+				t.Errorf("paths %q, want %q", paths, test.paths)
+			}
+			if !reflect.DeepEqual(input.ExcludeFiles(), test.excludeFiles) {
+				t.Errorf("exclude_files %q, want %q", input.ExcludeFiles(), test.excludeFiles)
+			}
+			collected := make([]string, 0)
+			for _, path := range input.collectedFiles(osFileSystem{}) {
+				collected = append(collected, strings.TrimPrefix(path, dir+"/"))
+			}
+			sort.Strings(collected)
+			if strings.Join(collected, ",") != strings.Join(test.collected, ",") {
+				t.Fatalf("collected %v, want %v", collected, test.collected)
+			}
+		})
+	}
+}
+
+func TestParseRotation(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{value: "", want: ""},
+		{value: "rename", want: RotationRename},
+		{value: " copytruncate ", want: RotationCopyTruncate},
+		{value: "dated", want: RotationDated},
+		{value: "daily", wantErr: true},
+	}
+	for _, test := range tests {
+		node := newLogInfoNode("/var/log/app/app.log")
+		if test.value != "" {
+			node.children["rotation"] = newLogInfoNode(test.value)
+		}
+		got, err := node.parseRotation()
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Errorf("parseRotation(%q) = %q, %v, want %q, error %v", test.value, got, err, test.want, test.wantErr)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const metricsNamespace = "kube_log_helper"
+
+var (
+	activeInputs = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "active_inputs",
+		Help:      "Number of filebeat inputs rendered on this node.",
+	}, []string{"namespace", "format"})
+
+	inputRenderFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "input_render_failures_total",
+		Help:      "Number of log sources or pods whose filebeat inputs could not be rendered.",
+	}, []string{"reason"})
+
+	inputWriteDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "input_write_duration_seconds",
+		Help:      "Latency of writing a pod's input file into the filebeat reload directory.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 12),
+	})
+
+	filebeatRestarts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "filebeat_restarts_total",
+		Help:      "Number of times the filebeat process was restarted, after it exited or was stopped by the helper.",
+	}, []string{"reason"})
+
+	filebeatConfigReloads = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "filebeat_config_reloads_total",
+		Help:      "Number of times filebeat was restarted to apply a changed filebeat.yml.",
+	})
+
+	filebeatUp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "filebeat_up",
+		Help:      "Whether the filebeat process is running (1) or not (0).",
+	})
+
+	parseErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "parse_errors_total",
+		Help:      "Number of log declarations that failed to parse, by env prefix or declaring resource.",
+	}, []string{"prefix"})
+
+	inputLagBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "input_lag_bytes",
+		Help:      "Size of the files tracked by an input minus their offsets in the filebeat registry.",
+	}, inputLabels)
+
+	inputStalled = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "input_stalled",
+		Help:      "Whether an input has unshipped bytes and no harvester progress for longer than the stall threshold.",
+	}, inputLabels)
+
+	registryEntries = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "filebeat_registry_entries",
+		Help:      "Number of file states in the filebeat registry on disk.",
+	})
+
+	registryEntriesRemoved = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "filebeat_registry_entries_removed_total",
+		Help:      "Number of registry entries removed for deleted files of deleted pods.",
+	})
+
+	diskQueueBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "filebeat_disk_queue_bytes",
+		Help:      "Size of the events buffered in the filebeat disk queue.",
+	})
+
+	diskQueueMaxBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "filebeat_disk_queue_max_bytes",
+		Help:      "max_size of the filebeat disk queue, 0 when events are queued in memory.",
+	})
+
+	diskQueueFillRatio = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "filebeat_disk_queue_fill_ratio",
+		Help:      "Fraction of the filebeat disk queue in use, filebeat stops reading logs at 1.",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		activeInputs,
+		inputRenderFailures,
+		inputWriteDuration,
+		filebeatRestarts,
+		filebeatConfigReloads,
+		filebeatUp,
+		parseErrors,
+		inputLagBytes,
+		inputStalled,
+		registryEntries,
+		registryEntriesRemoved,
+		diskQueueBytes,
+		diskQueueMaxBytes,
+		diskQueueFillRatio,
+	)
+}
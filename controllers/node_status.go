@@ -0,0 +1,330 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	crdk8sv1alpha1 "github.com/cccfs/kube-log-helper/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/klog/v2"
+	"os"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	nodeStatusReportInterval = 30 * time.Second
+	// an unchanged report is rewritten so the controller can tell a live agent from a gone one
+	nodeStatusHeartbeat  = 5 * time.Minute
+	nodeStatusStaleAfter = 3 * nodeStatusHeartbeat
+	maxFailedPods        = 10
+)
+
+// podResult is the outcome of the last render of a pod selected by WatchLogs.
+type podResult struct {
+	node string
+	// keyed by the WatchLogs selecting the pod
+	watchLogs map[string]*watchLogResult
+}
+
+// watchLogResult is the outcome of the log sources of a pod a WatchLog is reported:
+// the ones it declares and the ones the pod declares itself.
+type watchLogResult struct {
+	// latest failure message, empty when every log source was rendered
+	failure string
+	indices []crdk8sv1alpha1.IndexSource
+}
+
+func newPodResult(clp *ContainerLogOptions) podResult {
+	selecting := clp.watchLogNames()
+	result := podResult{node: clp.nodeName, watchLogs: make(map[string]*watchLogResult, len(selecting))}
+	for _, name := range selecting {
+		result.watchLogs[name] = &watchLogResult{}
+	}
+	for _, input := range clp.inputConfigList {
+		for _, name := range sourceWatchLogs(input.watchLog, selecting) {
+			result.watchLogs[name].indices = append(result.watchLogs[name].indices, clp.indexSource(input))
+		}
+	}
+	for _, failure := range clp.failures {
+		for _, name := range sourceWatchLogs(failure.watchLog, selecting) {
+			result.watchLogs[name].failure = failure.message()
+		}
+	}
+	return result
+}
+
+// sourceWatchLogs returns the WatchLogs a log source is reported to: the one declaring
+// it, every WatchLog selecting the pod for the sources the pod declares itself.
+func sourceWatchLogs(declaredBy string, selecting []string) []string {
+	if declaredBy != "" {
+		return []string{declaredBy}
+	}
+	return selecting
+}
+
+// resultStore keeps the per pod results of this node and the unhealthy inputs
+// found by the ShippingMonitor, reported per WatchLog by the NodeStatusReporter.
+type resultStore struct {
+	sync.Mutex
+	pods      map[types.NamespacedName]podResult
+	unhealthy map[types.NamespacedName][]crdk8sv1alpha1.InputHealth
+}
+
+var nodeResults = &resultStore{
+	pods:      make(map[types.NamespacedName]podResult),
+	unhealthy: make(map[types.NamespacedName][]crdk8sv1alpha1.InputHealth),
+}
+
+func (s *resultStore) setPod(pod types.NamespacedName, result podResult) {
+	s.Lock()
+	defer s.Unlock()
+	if len(result.watchLogs) == 0 {
+		delete(s.pods, pod)
+		return
+	}
+	s.pods[pod] = result
+}
+
+func (s *resultStore) deletePod(pod types.NamespacedName) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.pods, pod)
+}
+
+// setUnhealthy replaces the unhealthy inputs, keyed by WatchLog.
+func (s *resultStore) setUnhealthy(unhealthy map[types.NamespacedName][]crdk8sv1alpha1.InputHealth) {
+	s.Lock()
+	defer s.Unlock()
+	s.unhealthy = unhealthy
+}
+
+// build returns the status of every WatchLog selecting a pod of this node.
+func (s *resultStore) build() map[types.NamespacedName]*crdk8sv1alpha1.WatchLogNodeStatusStatus {
+	s.Lock()
+	defer s.Unlock()
+	statuses := make(map[types.NamespacedName]*crdk8sv1alpha1.WatchLogNodeStatusStatus)
+	get := func(key types.NamespacedName) *crdk8sv1alpha1.WatchLogNodeStatusStatus {
+		if statuses[key] == nil {
+			statuses[key] = &crdk8sv1alpha1.WatchLogNodeStatusStatus{}
+		}
+		return statuses[key]
+	}
+	for pod, result := range s.pods {
+		for name, sources := range result.watchLogs {
+			status := get(types.NamespacedName{Namespace: pod.Namespace, Name: name})
+			status.Indices = append(status.Indices, sources.indices...)
+			status.Matched++
+			if sources.failure == "" {
+				status.Collected++
+				continue
+			}
+			status.Failed++
+			status.FailedPods = append(status.FailedPods, crdk8sv1alpha1.PodFailure{
+				Node:    result.node,
+				Pod:     pod.Name,
+				Message: sources.failure,
+			})
+		}
+	}
+	for key, inputs := range s.unhealthy {
+		status := get(key)
+		status.UnhealthyInputs = append(status.UnhealthyInputs, inputs...)
+	}
+	for _, status := range statuses {
+		sortFailedPods(status.FailedPods)
+		if len(status.FailedPods) > maxFailedPods {
+			status.FailedPods = status.FailedPods[:maxFailedPods]
+		}
+		sortInputHealth(status.UnhealthyInputs)
+		status.Indices = uniqueIndexSources(status.Indices)
+	}
+	return statuses
+}
+
+// uniqueIndexSources sorts the index sources and removes the duplicates, pods of a
+// workload all ship to the same indices.
+func uniqueIndexSources(indices []crdk8sv1alpha1.IndexSource) []crdk8sv1alpha1.IndexSource {
+	sort.Slice(indices, func(i, j int) bool {
+		if indices[i].Index != indices[j].Index {
+			return indices[i].Index < indices[j].Index
+		}
+		if indices[i].Source != indices[j].Source {
+			return indices[i].Source < indices[j].Source
+		}
+		return indices[i].Topic < indices[j].Topic
+	})
+	unique := indices[:0]
+	for i, index := range indices {
+		if i == 0 || index != indices[i-1] {
+			unique = append(unique, index)
+		}
+	}
+	if len(unique) == 0 {
+		return nil
+	}
+	return unique
+}
+
+func sortFailedPods(pods []crdk8sv1alpha1.PodFailure) {
+	sort.Slice(pods, func(i, j int) bool {
+		if pods[i].Node != pods[j].Node {
+			return pods[i].Node < pods[j].Node
+		}
+		return pods[i].Pod < pods[j].Pod
+	})
+}
+
+func sortInputHealth(inputs []crdk8sv1alpha1.InputHealth) {
+	sort.Slice(inputs, func(i, j int) bool {
+		a, b := inputs[i], inputs[j]
+		if a.Node != b.Node {
+			return a.Node < b.Node
+		}
+		if a.Pod != b.Pod {
+			return a.Pod < b.Pod
+		}
+		if a.Container != b.Container {
+			return a.Container < b.Container
+		}
+		return a.Source < b.Source
+	})
+}
+
+// nodeStatusName is the name of the WatchLogNodeStatus of a WatchLog and node: the
+// WatchLog name followed by a hash of both names, the node is in the spec and labels.
+// Joining the names could collide as both may hold dots, and exceed the name length.
+func nodeStatusName(watchLog, node string) string {
+	hash := fmt.Sprintf("%x", sha256.Sum256([]byte(watchLog+"/"+node)))[:16]
+	prefix := watchLog
+	if max := validation.DNS1123SubdomainMaxLength - len(hash) - 1; len(prefix) > max {
+		prefix = strings.TrimRight(prefix[:max], ".-")
+	}
+	return prefix + "." + hash
+}
+
+// LabelValue is name when it is a valid label value, its hash when it is longer
+// than a label value can be.
+func LabelValue(name string) string {
+	if len(validation.IsValidLabelValue(name)) == 0 {
+		return name
+	}
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(name)))[:validation.LabelValueMaxLength]
+}
+
+// NodeStatusReporter writes one WatchLogNodeStatus per WatchLog selecting pods of
+// this node, owned by the WatchLog, and removes the ones no pod is selected for.
+type NodeStatusReporter struct {
+	client   client.Client
+	nodeName string
+}
+
+func NewNodeStatusReporter(c client.Client) *NodeStatusReporter {
+	return &NodeStatusReporter{
+		client:   c,
+		nodeName: os.Getenv(EnvNodeName),
+	}
+}
+
+// NeedLeaderElection is false, every node reports its own results.
+func (r *NodeStatusReporter) NeedLeaderElection() bool {
+	return false
+}
+
+func (r *NodeStatusReporter) Start(ctx context.Context) error {
+	ticker := time.NewTicker(nodeStatusReportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := r.report(ctx); err != nil {
+				klog.Warningf("unable to report node status: %v", err)
+			}
+		}
+	}
+}
+
+func (r *NodeStatusReporter) report(ctx context.Context) error {
+	existing := &crdk8sv1alpha1.WatchLogNodeStatusList{}
+	if err := r.client.List(ctx, existing, client.MatchingLabels{LabelNode: LabelValue(r.nodeName)}); err != nil {
+		return err
+	}
+	statuses := nodeResults.build()
+	for i := range existing.Items {
+		item := &existing.Items[i]
+		key := types.NamespacedName{Namespace: item.Namespace, Name: item.Spec.WatchLog}
+		status, ok := statuses[key]
+		if !ok {
+			if err := r.client.Delete(ctx, item); client.IgnoreNotFound(err) != nil {
+				klog.Warningf("unable to delete watchlognodestatus %s/%s: %v", item.Namespace, item.Name, err)
+			}
+			continue
+		}
+		delete(statuses, key)
+		if err := r.update(ctx, item, status); err != nil {
+			klog.Warningf("unable to update watchlognodestatus %s/%s: %v", item.Namespace, item.Name, err)
+		}
+	}
+	for key, status := range statuses {
+		if err := r.create(ctx, key, status); err != nil {
+			klog.Warningf("unable to create watchlognodestatus for watchlog %s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+func (r *NodeStatusReporter) create(ctx context.Context, key types.NamespacedName, status *crdk8sv1alpha1.WatchLogNodeStatusStatus) error {
+	watchLog := &crdk8sv1alpha1.WatchLog{}
+	if err := r.client.Get(ctx, key, watchLog); err != nil {
+		// deleted since the pods were rendered, they are reconciled again
+		return client.IgnoreNotFound(err)
+	}
+	item := &crdk8sv1alpha1.WatchLogNodeStatus{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: key.Namespace,
+			Name:      nodeStatusName(key.Name, r.nodeName),
+			Labels: map[string]string{
+				LabelWatchLog: LabelValue(key.Name),
+				LabelNode:     LabelValue(r.nodeName),
+			},
+		},
+		Spec: crdk8sv1alpha1.WatchLogNodeStatusSpec{
+			WatchLog: key.Name,
+			NodeName: r.nodeName,
+		},
+	}
+	// garbage collected with the WatchLog
+	if err := controllerutil.SetControllerReference(watchLog, item, r.client.Scheme()); err != nil {
+		return err
+	}
+	if err := r.client.Create(ctx, item); err != nil {
+		if !errors.IsAlreadyExists(err) {
+			return err
+		}
+		// created by a previous report not yet in the cache, update it at its resourceVersion
+		if err := r.client.Get(ctx, client.ObjectKeyFromObject(item), item); err != nil {
+			return err
+		}
+	}
+	return r.update(ctx, item, status)
+}
+
+func (r *NodeStatusReporter) update(ctx context.Context, item *crdk8sv1alpha1.WatchLogNodeStatus, status *crdk8sv1alpha1.WatchLogNodeStatusStatus) error {
+	status.LastUpdateTime = item.Status.LastUpdateTime
+	if equality.Semantic.DeepEqual(*status, item.Status) && time.Since(item.Status.LastUpdateTime.Time) < nodeStatusHeartbeat {
+		return nil
+	}
+	item.Status = *status
+	item.Status.LastUpdateTime = metav1.NewTime(time.Now().Truncate(time.Second))
+	return r.client.Status().Update(ctx, item)
+}
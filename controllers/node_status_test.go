@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	crdk8sv1alpha1 "github.com/cccfs/kube-log-helper/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+func TestNodeStatusName(t *testing.T) {
+	long := strings.Repeat("a", 250)
+	names := map[string]bool{}
+	for _, pair := range [][2]string{
+		{"app", "node-1"},
+		{"app", "node-2"},
+		{"app.b", "c"},
+		{"app", "b.c"},
+		{long, "node-1"},
+		{long + "b", "node-1"},
+	} {
+		name := nodeStatusName(pair[0], pair[1])
+		if errs := validation.IsDNS1123Subdomain(name); len(errs) != 0 {
+			t.Errorf("nodeStatusName(%q, %q) = %q: %v", pair[0], pair[1], name, errs)
+		}
+		if names[name] {
+			t.Errorf("nodeStatusName(%q, %q) = %q is already used", pair[0], pair[1], name)
+		}
+		names[name] = true
+	}
+}
+
+func TestLabelValue(t *testing.T) {
+	if got := LabelValue("node-1.example.com"); got != "node-1.example.com" {
+		t.Errorf("LabelValue kept %q", got)
+	}
+	long := strings.Repeat("node.", 20) + "example.com"
+	got := LabelValue(long)
+	if errs := validation.IsValidLabelValue(got); len(errs) != 0 {
+		t.Errorf("LabelValue(%q) = %q: %v", long, got, errs)
+	}
+	if got == LabelValue(long+"x") {
+		t.Errorf("LabelValue hashes different names to %q", got)
+	}
+}
+
+// a WatchLog is only reported the sources it declares and the ones of the pod
+func TestResultStoreBuildPerWatchLog(t *testing.T) {
+	pod := testPod(corev1.Container{
+		Name:         "app",
+		VolumeMounts: []corev1.VolumeMount{{Name: "logs", MountPath: "/var/log/app"}},
+		Env:          logEnv("k8s_logs_app", "stdout"),
+	})
+	watchLog := func(name string, source crdk8sv1alpha1.LogSource) crdk8sv1alpha1.WatchLog {
+		return crdk8sv1alpha1.WatchLog{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: pod.Namespace},
+			Spec:       crdk8sv1alpha1.WatchLogSpec{Sources: []crdk8sv1alpha1.LogSource{source}},
+		}
+	}
+	watchLogs := []crdk8sv1alpha1.WatchLog{
+		watchLog("audit", crdk8sv1alpha1.LogSource{Name: "audit", Output: "/var/log/app/audit.log"}),
+		// rotation does not apply to stdout
+		watchLog("broken", crdk8sv1alpha1.LogSource{Name: "events", Output: "stdout", Rotation: "rename"}),
+	}
+	helper, err := LogHelperInit(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clp, err := newContainerLogOptions(helper, pod, watchLogs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clp.failures) != 1 {
+		t.Fatalf("got %d failures, want the one of the broken source", len(clp.failures))
+	}
+
+	store := &resultStore{pods: make(map[types.NamespacedName]podResult)}
+	store.setPod(types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}, newPodResult(clp))
+	statuses := store.build()
+	indices := func(status *crdk8sv1alpha1.WatchLogNodeStatusStatus) []string {
+		names := make([]string, 0)
+		for _, index := range status.Indices {
+			names = append(names, index.Source)
+		}
+		return names
+	}
+
+	audit := statuses[types.NamespacedName{Namespace: pod.Namespace, Name: "audit"}]
+	if audit == nil || audit.Collected != 1 || audit.Failed != 0 {
+		t.Fatalf("audit status = %+v, want its pod collected", audit)
+	}
+	if want := []string{"team-a/app", "team-a/audit"}; !reflect.DeepEqual(indices(audit), want) {
+		t.Errorf("audit indices %v, want %v", indices(audit), want)
+	}
+	broken := statuses[types.NamespacedName{Namespace: pod.Namespace, Name: "broken"}]
+	if broken == nil || broken.Failed != 1 || len(broken.FailedPods) != 1 {
+		t.Fatalf("broken status = %+v, want its pod failed", broken)
+	}
+	if want := []string{"team-a/app"}; !reflect.DeepEqual(indices(broken), want) {
+		t.Errorf("broken indices %v, want %v", indices(broken), want)
+	}
+}
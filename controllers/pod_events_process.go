@@ -2,9 +2,31 @@ package controllers
 
 import (
 	"fmt"
+	crdk8sv1alpha1 "github.com/cccfs/kube-log-helper/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 	"k8s.io/klog/v2"
+	"strings"
 )
 
+// multiWordKeys are the keys of a log source holding an underscore.
+var multiWordKeys = []string{"include_lines", "exclude_lines", "drop_event"}
+
+// splitLogKeys splits an env var name without its prefix into the log source and
+// its keys, e.g. xxx_format_pattern is [xxx format pattern], xxx_include_lines
+// is [xxx include_lines].
+func splitLogKeys(name string) []string {
+	keys := strings.SplitN(name, "_", 2)
+	if len(keys) == 1 {
+		return keys
+	}
+	for _, key := range multiWordKeys {
+		if keys[1] == key {
+			return keys
+		}
+	}
+	return append(keys[:1], strings.Split(keys[1], "_")...)
+}
+
 type LogInfoNode struct {
 	value    string
 	children map[string]*LogInfoNode
@@ -49,19 +71,19 @@ func (node *LogInfoNode) parseTags() (map[string]string, error) {
 }
 
 func (node *LogInfoNode) parseCustomConfig() (map[string]string, error) {
-	// prefix_logs_xxx_config: "multiline.pattern='^[0-9]{3}.*',multiline.negate=true,multiline.match=after"
+	// prefix_logs_xxx_config: "close_eof=true,harvester_buffer_size=65536"
 	config := node.get("config")
 	return ParseBlocks(config)
 }
 
-func (node *LogInfoNode) parseCovertIndex(tagsMap map[string]string) error {
+func (node *LogInfoNode) parseCovertIndex(name string, tagsMap map[string]string) error {
 	// prefix_logs_xxx_index: "project-demo-log"
 	indexName := node.get("index")
 	if _, ok := tagsMap["index"]; !ok {
 		if indexName != "" {
 			tagsMap["index"] = indexName
 		} else {
-			tagsMap["index"] = node.value
+			tagsMap["index"] = name
 		}
 	}
 
@@ -70,13 +92,13 @@ func (node *LogInfoNode) parseCovertIndex(tagsMap map[string]string) error {
 		if indexName != "" {
 			tagsMap["topic"] = indexName
 		} else {
-			tagsMap["topic"] = node.value
+			tagsMap["topic"] = name
 		}
 	}
 	return nil
 }
 
-func (node *LogInfoNode) parseLogFormat(tagsMap map[string]string) error {
+func (node *LogInfoNode) parseLogFormat(tagsMap map[string]string) (string, error) {
 	// prefix_logs_xxx_format: "none|json|csv|nginx|apache2|regexp"
 	format := node.children["format"]
 	if format == nil || format.value == "none" {
@@ -84,14 +106,66 @@ func (node *LogInfoNode) parseLogFormat(tagsMap map[string]string) error {
 	}
 	formatConfig, err := Convert(format)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	if format.value == "regexp" {
 		format.value = fmt.Sprintf("/%s/", formatConfig["pattern"])
 		delete(formatConfig, "pattern")
 	}
-	return nil
+	return format.value, nil
+}
+
+// parseLineFilters validates the line filters of a log source:
+//
+//	prefix_logs_xxx_include_lines: "^ERROR|^WARN"
+//	prefix_logs_xxx_exclude_lines: "GET /healthz"
+//	prefix_logs_xxx_drop_event: "json.path=^/metrics$", one field=pattern per line
+func (node *LogInfoNode) parseLineFilters() (crdk8sv1alpha1.LineFilters, error) {
+	filters := crdk8sv1alpha1.LineFilters{}
+	if include := node.get("include_lines"); include != "" {
+		filters.IncludeLines = []string{include}
+	}
+	if exclude := node.get("exclude_lines"); exclude != "" {
+		filters.ExcludeLines = []string{exclude}
+	}
+	for _, line := range strings.Split(node.get("drop_event"), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return filters, fmt.Errorf("drop_event %q is not a valid field=pattern", line)
+		}
+		filters.DropEvents = append(filters.DropEvents, crdk8sv1alpha1.DropEventRule{
+			Field:   strings.TrimSpace(parts[0]),
+			Pattern: parts[1],
+		})
+	}
+	if errs := crdk8sv1alpha1.ValidateLineFilters(filters, field.NewPath("")); len(errs) > 0 {
+		return filters, errs.ToAggregate()
+	}
+	return filters, nil
+}
+
+// joinPatterns matches any of the patterns with a single regular expression.
+func joinPatterns(patterns []string) string {
+	if len(patterns) == 1 {
+		return patterns[0]
+	}
+	groups := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		groups = append(groups, "(?:"+pattern+")")
+	}
+	return strings.Join(groups, "|")
+}
+
+func formatDropEvents(rules []crdk8sv1alpha1.DropEventRule) string {
+	lines := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		lines = append(lines, rule.Field+"="+rule.Pattern)
+	}
+	return strings.Join(lines, "\n")
 }
 
 func (node *LogInfoNode) parseDefaultJavaLog() bool {
@@ -104,20 +178,99 @@ func (node *LogInfoNode) parseDefaultJavaLog() bool {
 	return multiLine
 }
 
-//func (node *LogInfoNode) parseLogOutputType(logPath string) (string, error) {
-//	// prefix_logs_xxx: "stdout"
-//	path := strings.TrimSpace(node.value)
-//	if path == "" {
-//		return ""
-//	}
-//
-//	// collection container stdout type logs
-//	if logPath == "stdout" {
-//		logFile := filepath.Base(logPath) + "*"
-//	}
-//	return Render(FilebeatInputConfTemplate, Data{
-//		"Stdout": true,
-//		"Multiline": node.parseDefaultJavaLog(),
-//		"HostDir":
-//	})
-//}
+func (node *LogInfoNode) parseMultiline() (string, error) {
+	// prefix_logs_xxx_multiline: "java"
+	preset := node.get("multiline")
+	if preset == "" && node.parseDefaultJavaLog() {
+		preset = "java"
+	}
+	if preset == "" {
+		return "", nil
+	}
+	pattern, ok := multilinePresets[preset]
+	if !ok {
+		return "", fmt.Errorf("unsupported multiline preset: %s", preset)
+	}
+	return pattern, nil
+}
+
+// mergeWatchLog adds the WatchLog sources of a container, sources declared by the container win.
+func (node *LogInfoNode) mergeWatchLog(containerName string, spec *crdk8sv1alpha1.WatchLogSpec) {
+	for _, source := range spec.Sources {
+		if source.Container != "" && source.Container != containerName {
+			continue
+		}
+		if _, ok := node.children[source.Name]; ok {
+			continue
+		}
+		config := limitsConfig(source.LogLimits)
+		for k, v := range source.Config {
+			config[k] = v
+		}
+		child := newLogInfoNode(source.Output)
+		for key, value := range map[string]string{
+			"index":     source.Index,
+			"format":    source.Format,
+			"multiline": source.Multiline,
+			"rotation":  source.Rotation,
+			"tags":      FormatBlocks(source.Tags),
+			"config":    FormatBlocks(config),
+			// validated again when the log source is parsed
+			"include_lines": joinPatterns(source.IncludeLines),
+			"exclude_lines": joinPatterns(source.ExcludeLines),
+			"drop_event":    formatDropEvents(source.DropEvents),
+		} {
+			if value != "" {
+				child.children[key] = newLogInfoNode(value)
+			}
+		}
+		node.children[source.Name] = child
+	}
+}
+
+// mergePolicy fills the namespace LogPolicy defaults into every log source
+// that does not declare the value itself.
+func (node *LogInfoNode) mergePolicy(containerName string, policy *crdk8sv1alpha1.LogPolicySpec) {
+	if len(node.children) == 0 && policy.Output != "" {
+		node.children[containerName] = newLogInfoNode(policy.Output)
+	}
+
+	for _, child := range node.children {
+		if policy.Index != "" && child.get("index") == "" {
+			child.children["index"] = newLogInfoNode(policy.Index)
+		}
+		if policy.Format != "" && child.get("format") == "" {
+			child.children["format"] = newLogInfoNode(policy.Format)
+		}
+		if policy.Multiline != "" && child.get("multiline") == "" && child.get("java") == "" {
+			child.children["multiline"] = newLogInfoNode(policy.Multiline)
+		}
+		if limits := limitsConfig(policy.LogLimits); len(limits) > 0 {
+			// invalid config is reported when the log source itself is parsed
+			config, err := child.parseCustomConfig()
+			if err == nil {
+				for k, v := range limits {
+					if _, ok := config[k]; !ok {
+						config[k] = v
+					}
+				}
+				child.children["config"] = newLogInfoNode(FormatBlocks(config))
+			}
+		}
+		if len(policy.Tags) > 0 {
+			// invalid tags are reported when the log source itself is parsed
+			tags, err := child.parseTags()
+			if err != nil {
+				continue
+			}
+			merged := make(map[string]string)
+			for k, v := range policy.Tags {
+				merged[k] = v
+			}
+			for k, v := range tags {
+				merged[k] = v
+			}
+			child.children["tags"] = newLogInfoNode(FormatBlocks(merged))
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitLogKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{name: "app", want: []string{"app"}},
+		{name: "app_format", want: []string{"app", "format"}},
+		{name: "app_format_pattern", want: []string{"app", "format", "pattern"}},
+		{name: "app_include_lines", want: []string{"app", "include_lines"}},
+		{name: "app_exclude_lines", want: []string{"app", "exclude_lines"}},
+		{name: "app_drop_event", want: []string{"app", "drop_event"}},
+		{name: "app_include_lines_extra", want: []string{"app", "include", "lines", "extra"}},
+	}
+	for _, test := range tests {
+		if got := splitLogKeys(test.name); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("splitLogKeys(%q) = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+// logInfoTree inserts the env vars, named without their prefix, as the pod
+// controller does.
+func logInfoTree(env ...string) *LogInfoNode {
+	root := newLogInfoNode("")
+	for i := 0; i+1 < len(env); i += 2 {
+		root.insert(splitLogKeys(env[i]), env[i+1])
+	}
+	return root
+}
+
+func TestLogInfoNodeInsert(t *testing.T) {
+	root := logInfoTree(
+		"app", "stdout",
+		"app_format", "regexp",
+		"app_format_pattern", "^(?P<message>.*)$",
+		"app_include_lines", "^ERROR",
+		// no parent source, ignored
+		"orphan_format", "json",
+		// replaces the first declaration
+		"app_tags", "env=test",
+		"app_tags", "env=prod",
+	)
+	tests := []struct {
+		path []string
+		want string
+	}{
+		{path: []string{"app"}, want: "stdout"},
+		{path: []string{"app", "format"}, want: "regexp"},
+		{path: []string{"app", "format", "pattern"}, want: "^(?P<message>.*)$"},
+		{path: []string{"app", "include_lines"}, want: "^ERROR"},
+		{path: []string{"app", "tags"}, want: "env=prod"},
+		{path: []string{"app", "index"}, want: ""},
+		{path: []string{"orphan"}, want: ""},
+	}
+	for _, test := range tests {
+		node := root
+		for _, key := range test.path[:len(test.path)-1] {
+			node = node.children[key]
+		}
+		if got := node.get(test.path[len(test.path)-1]); got != test.want {
+			t.Errorf("get(%v) = %q, want %q", test.path, got, test.want)
+		}
+	}
+	if len(root.children) != 1 {
+		t.Errorf("root has %d sources, want 1", len(root.children))
+	}
+}
+
+func TestLogInfoNodeParse(t *testing.T) {
+	tests := []struct {
+		name      string
+		env       []string
+		format    string
+		multiline string
+		tags      map[string]string
+		wantErr   bool
+	}{
+		{
+			name:   "defaults",
+			env:    []string{"app", "stdout"},
+			format: "none",
+			tags:   map[string]string{"index": "app", "topic": "app"},
+		},
+		{
+			name:   "index and tags",
+			env:    []string{"app", "stdout", "app_index", "demo", "app_tags", "env=test,topic=events"},
+			format: "none",
+			tags:   map[string]string{"env": "test", "index": "demo", "topic": "events"},
+		},
+		{
+			name:   "regexp format",
+			env:    []string{"app", "stdout", "app_format", "regexp", "app_format_pattern", "^(?P<message>.*)$"},
+			format: "/^(?P<message>.*)$/",
+			tags:   map[string]string{"index": "app", "topic": "app"},
+		},
+		{
+			name:      "java multiline",
+			env:       []string{"app", "stdout", "app_java", "true"},
+			format:    "none",
+			multiline: multilinePresets["java"],
+			tags:      map[string]string{"index": "app", "topic": "app"},
+		},
+		{name: "invalid tags", env: []string{"app", "stdout", "app_tags", "env"}, wantErr: true},
+		{name: "unknown format", env: []string{"app", "stdout", "app_format", "logfmt"}, wantErr: true},
+		{name: "regexp without pattern", env: []string{"app", "stdout", "app_format", "regexp"}, wantErr: true},
+		{name: "unknown multiline", env: []string{"app", "stdout", "app_multiline", "python"}, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node := logInfoTree(test.env...).children["app"]
+			tags, err := node.parseTags()
+			if err == nil {
+				err = node.parseCovertIndex("app", tags)
+			}
+			var format, multiline string
+			if err == nil {
+				format, err = node.parseLogFormat(tags)
+			}
+			if err == nil {
+				multiline, err = node.parseMultiline()
+			}
+			if (err != nil) != test.wantErr {
+				t.Fatalf("error = %v, want error %v", err, test.wantErr)
+			}
+			if test.wantErr {
+				return
+			}
+			if format != test.format {
+				t.Errorf("format = %q, want %q", format, test.format)
+			}
+			if multiline != test.multiline {
+				t.Errorf("multiline = %q, want %q", multiline, test.multiline)
+			}
+			if !reflect.DeepEqual(tags, test.tags) {
+				t.Errorf("tags = %v, want %v", tags, test.tags)
+			}
+		})
+	}
+}
+
+func TestLogInfoNodeParseLineFilters(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     []string
+		drops   int
+		wantErr bool
+	}{
+		{name: "none", env: []string{"app", "stdout"}},
+		{name: "include and exclude", env: []string{"app", "stdout", "app_include_lines", "^ERROR", "app_exclude_lines", "healthz"}},
+		{name: "drop events", env: []string{"app", "stdout", "app_drop_event", "json.path=^/metrics$\n\nmessage=healthz"}, drops: 2},
+		{name: "drop event without a pattern", env: []string{"app", "stdout", "app_drop_event", "json.path"}, wantErr: true},
+		{name: "invalid include", env: []string{"app", "stdout", "app_include_lines", "(ERROR"}, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filters, err := logInfoTree(test.env...).children["app"].parseLineFilters()
+			if (err != nil) != test.wantErr {
+				t.Fatalf("error = %v, want error %v", err, test.wantErr)
+			}
+			if !test.wantErr && len(filters.DropEvents) != test.drops {
+				t.Errorf("%d drop events, want %d", len(filters.DropEvents), test.drops)
+			}
+		})
+	}
+}
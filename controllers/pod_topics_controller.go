@@ -0,0 +1,70 @@
+package controllers
+
+import (
+	"context"
+	crdk8sv1alpha1 "github.com/cccfs/kube-log-helper/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+	"time"
+)
+
+// topicRetryInterval is how long a pod waits before the topics that could not be
+// provisioned are tried again.
+const topicRetryInterval = time.Minute
+
+// PodTopicReconciler creates the missing kafka topics of the log sources of pods,
+// declared in their container env or by the WatchLogs selecting them. It runs in
+// controller mode and renders the topics with the LOGGING_* env of the controller,
+// which has to match the one of the agents.
+type PodTopicReconciler struct {
+	client.Client
+	Recorder record.EventRecorder
+	Topics   *TopicProvisioner
+}
+
+func (r *PodTopicReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	pod := &corev1.Pod{}
+	if err := r.Client.Get(ctx, req.NamespacedName, pod); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if pod.DeletionTimestamp != nil {
+		return ctrl.Result{}, nil
+	}
+	w := &WatchLogReconciler{Client: r.Client}
+	_, _, clp, err := w.podLogOptions(ctx, pod)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	topics := make(map[string]bool)
+	for _, input := range clp.inputConfigList {
+		if topic := input.Tags["topic"]; topic != "" {
+			topics[topic] = true
+		}
+	}
+	result := ctrl.Result{}
+	for topic := range topics {
+		if err := r.Topics.ensure(topic); err != nil {
+			klog.Warningf("unable to provision kafka topic %s of pod %s: %v", topic, req.NamespacedName, err)
+			r.Recorder.Eventf(pod, corev1.EventTypeWarning, EventReasonTopicNotProvisioned, "kafka topic %s: %v", topic, err)
+			// a broker may be unavailable for a while, the pod may not change again
+			result.RequeueAfter = topicRetryInterval
+		}
+	}
+	return result, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *PodTopicReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// a WatchLog selecting the pod adds sources
+	w := &WatchLogReconciler{Client: r.Client}
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("podtopics").
+		For(&corev1.Pod{}).
+		Watches(&source.Kind{Type: &crdk8sv1alpha1.WatchLog{}}, handler.EnqueueRequestsFromMapFunc(w.podsInNamespace)).
+		Complete(r)
+}
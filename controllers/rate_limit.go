@@ -0,0 +1,166 @@
+package controllers
+
+import (
+	"fmt"
+	crdk8sv1alpha1 "github.com/cccfs/kube-log-helper/api/v1alpha1"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// _config keys taken out of the input options
+	configRateLimit      = "rate_limit"
+	configRateLimitBytes = "rate_limit_bytes"
+	configMaxBytes       = "max_bytes"
+)
+
+// eventRate is a filebeat rate_limit limit, e.g. 100/s.
+type eventRate struct {
+	events int64
+	per    time.Duration
+}
+
+var rateUnits = map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour}
+
+func parseEventRate(value string) (*eventRate, error) {
+	parts := strings.Split(strings.TrimSpace(value), "/")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid rate limit %q, expected <events>/s|m|h", value)
+	}
+	events, err := strconv.ParseInt(parts[0], 10, 64)
+	per, ok := rateUnits[parts[1]]
+	if err != nil || events <= 0 || !ok {
+		return nil, fmt.Errorf("invalid rate limit %q, expected <events>/s|m|h", value)
+	}
+	return &eventRate{events: events, per: per}, nil
+}
+
+func (r *eventRate) perSecond() float64 {
+	return float64(r.events) / r.per.Seconds()
+}
+
+func (r *eventRate) String() string {
+	for unit, per := range rateUnits {
+		if per == r.per {
+			return fmt.Sprintf("%d/%s", r.events, unit)
+		}
+	}
+	return ""
+}
+
+func parseBytes(key, value string) (int64, error) {
+	n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid %s %q, expected a number of bytes", key, value)
+	}
+	return n, nil
+}
+
+// LimitOptions are the mandatory ceilings set by the cluster admins, a log source
+// declaring no limit or a higher one gets the ceiling.
+type LimitOptions struct {
+	rateLimit      *eventRate
+	rateLimitBytes int64
+	maxBytes       int64
+}
+
+func limitsInit() (*LimitOptions, error) {
+	// e.g: LOGGING_MAX_RATE_LIMIT="1000/s" LOGGING_MAX_RATE_LIMIT_BYTES="1048576" LOGGING_MAX_BYTES="65536"
+	limits := &LimitOptions{}
+	var err error
+	if v := os.Getenv(EnvLoggingMaxRateLimit); v != "" {
+		if limits.rateLimit, err = parseEventRate(v); err != nil {
+			return nil, fmt.Errorf("%s: %v", EnvLoggingMaxRateLimit, err)
+		}
+	}
+	if v := os.Getenv(EnvLoggingMaxRateLimitBytes); v != "" {
+		if limits.rateLimitBytes, err = parseBytes(EnvLoggingMaxRateLimitBytes, v); err != nil {
+			return nil, err
+		}
+	}
+	if v := os.Getenv(EnvLoggingMaxBytes); v != "" {
+		if limits.maxBytes, err = parseBytes(EnvLoggingMaxBytes, v); err != nil {
+			return nil, err
+		}
+	}
+	if limits.rateLimitBytes > 0 && limits.maxBytes == 0 {
+		return nil, fmt.Errorf("%s needs %s, the events are counted as %s bytes each", EnvLoggingMaxRateLimitBytes, EnvLoggingMaxBytes, EnvLoggingMaxBytes)
+	}
+	if limits.rateLimit == nil && limits.rateLimitBytes == 0 && limits.maxBytes == 0 {
+		return nil, nil
+	}
+	return limits, nil
+}
+
+// apply takes the limits out of the _config options of a log source and returns the
+// rate_limit and max_bytes to render, capped by the ceilings. Filebeat only limits
+// events, a bytes limit becomes a per minute limit of events of max_bytes each, so it
+// holds for the worst case of events of max_bytes and needs max_bytes to be set.
+func (l *LimitOptions) apply(customConfigs map[string]string) (string, int64, error) {
+	var rate *eventRate
+	var rateBytes, maxBytes int64
+	var err error
+	if v, ok := customConfigs[configRateLimit]; ok {
+		if rate, err = parseEventRate(v); err != nil {
+			return "", 0, err
+		}
+		delete(customConfigs, configRateLimit)
+	}
+	if v, ok := customConfigs[configRateLimitBytes]; ok {
+		if rateBytes, err = parseBytes(configRateLimitBytes, v); err != nil {
+			return "", 0, err
+		}
+		delete(customConfigs, configRateLimitBytes)
+	}
+	if v, ok := customConfigs[configMaxBytes]; ok {
+		if maxBytes, err = parseBytes(configMaxBytes, v); err != nil {
+			return "", 0, err
+		}
+		delete(customConfigs, configMaxBytes)
+	}
+
+	if l != nil {
+		if l.rateLimit != nil && (rate == nil || rate.perSecond() > l.rateLimit.perSecond()) {
+			rate = l.rateLimit
+		}
+		if l.rateLimitBytes > 0 && (rateBytes == 0 || rateBytes > l.rateLimitBytes) {
+			rateBytes = l.rateLimitBytes
+		}
+		if l.maxBytes > 0 && (maxBytes == 0 || maxBytes > l.maxBytes) {
+			maxBytes = l.maxBytes
+		}
+	}
+	if rateBytes > 0 {
+		if maxBytes == 0 {
+			return "", 0, fmt.Errorf("%s needs %s, the events are counted as %s bytes each", configRateLimitBytes, configMaxBytes, configMaxBytes)
+		}
+		byBytes := &eventRate{events: rateBytes * 60 / maxBytes, per: time.Minute}
+		if byBytes.events < 1 {
+			byBytes.events = 1
+		}
+		if rate == nil || byBytes.perSecond() < rate.perSecond() {
+			rate = byBytes
+		}
+	}
+	if rate == nil {
+		return "", maxBytes, nil
+	}
+	return rate.String(), maxBytes, nil
+}
+
+// limitsConfig is the _config form of the limits of a WatchLog or LogPolicy.
+func limitsConfig(limits crdk8sv1alpha1.LogLimits) map[string]string {
+	config := make(map[string]string)
+	if limits.RateLimit != "" {
+		config[configRateLimit] = limits.RateLimit
+	}
+	if limits.RateLimitBytes > 0 {
+		config[configRateLimitBytes] = strconv.FormatInt(limits.RateLimitBytes, 10)
+	}
+	if limits.MaxBytes > 0 {
+		config[configMaxBytes] = strconv.FormatInt(limits.MaxBytes, 10)
+	}
+	return config
+}
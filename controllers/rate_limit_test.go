@@ -0,0 +1,66 @@
+package controllers
+
+import (
+	"testing"
+)
+
+func TestLimitsApply(t *testing.T) {
+	tests := []struct {
+		name          string
+		limits        *LimitOptions
+		config        map[string]string
+		wantRateLimit string
+		wantMaxBytes  int64
+		wantErr       bool
+	}{
+		{name: "none", config: map[string]string{}},
+		{
+			name:          "rate limit",
+			config:        map[string]string{configRateLimit: "100/s", configMaxBytes: "65536"},
+			wantRateLimit: "100/s",
+			wantMaxBytes:  65536,
+		},
+		{
+			name:          "bytes limit counts events of max_bytes",
+			config:        map[string]string{configRateLimitBytes: "1024", configMaxBytes: "512"},
+			wantRateLimit: "120/m",
+			wantMaxBytes:  512,
+		},
+		{
+			name:          "lowest limit wins",
+			config:        map[string]string{configRateLimit: "1/s", configRateLimitBytes: "1024", configMaxBytes: "512"},
+			wantRateLimit: "1/s",
+			wantMaxBytes:  512,
+		},
+		{
+			name:    "bytes limit without max_bytes",
+			config:  map[string]string{configRateLimitBytes: "1024"},
+			wantErr: true,
+		},
+		{
+			name:          "max_bytes ceiling",
+			limits:        &LimitOptions{rateLimitBytes: 2048, maxBytes: 1024},
+			config:        map[string]string{configRateLimitBytes: "1048576"},
+			wantRateLimit: "120/m",
+			wantMaxBytes:  1024,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rateLimit, maxBytes, err := tt.limits.apply(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("apply() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if rateLimit != tt.wantRateLimit || maxBytes != tt.wantMaxBytes {
+				t.Errorf("apply() = %q, %d, want %q, %d", rateLimit, maxBytes, tt.wantRateLimit, tt.wantMaxBytes)
+			}
+		})
+	}
+}
+
+func TestLimitsInitBytesNeedMaxBytes(t *testing.T) {
+	t.Setenv(EnvLoggingMaxRateLimitBytes, "1048576")
+	if _, err := limitsInit(); err == nil {
+		t.Errorf("%s was accepted without %s", EnvLoggingMaxRateLimitBytes, EnvLoggingMaxBytes)
+	}
+}
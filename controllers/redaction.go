@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"fmt"
+	crdk8sv1alpha1 "github.com/cccfs/kube-log-helper/api/v1alpha1"
+	"io/ioutil"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"os"
+	"regexp"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// the field holding the line, json sources decode it under json. and keep the
+	// json.message_key of their inputs there
+	redactDefaultField = "message"
+	jsonMessageKey     = "log"
+)
+
+// redactField is the field a rule with none applies to.
+func redactField(format string) string {
+	if format == "json" {
+		return "json." + jsonMessageKey
+	}
+	return redactDefaultField
+}
+
+// redactionInit reads the cluster wide redaction rules, a YAML list of rules in the
+// file named by LOGGING_REDACTION_RULES_FILE, usually mounted from a ConfigMap.
+func redactionInit() ([]crdk8sv1alpha1.RedactionRule, error) {
+	file := os.Getenv(EnvLoggingRedactionRulesFile)
+	if file == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	rules := make([]crdk8sv1alpha1.RedactionRule, 0)
+	if err := yaml.UnmarshalStrict(data, &rules); err != nil {
+		return nil, fmt.Errorf("invalid redaction rules %s: %v", file, err)
+	}
+	if errs := crdk8sv1alpha1.ValidateRedactionRules(rules, field.NewPath(file)); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid redaction rules: %v", errs.ToAggregate())
+	}
+	return rules, nil
+}
+
+// redactionRules returns the rules of a log source in the order they apply:
+// cluster, namespace, then the ones of the WatchLog sources with the same name.
+// Rules without a field apply to the line of the source in the given format.
+func (clp *ContainerLogOptions) redactionRules(containerName, source, format string) ([]crdk8sv1alpha1.RedactionRule, error) {
+	// WatchLogs and LogPolicies created before the webhook was enabled are not validated yet,
+	// the names are unique within a LogPolicy or a WatchLog source, not across them
+	if errs := crdk8sv1alpha1.ValidateRedactionRules(clp.namespaceRedaction, field.NewPath("logpolicy", "redact")); len(errs) > 0 {
+		return nil, errs.ToAggregate()
+	}
+	rules := append(append([]crdk8sv1alpha1.RedactionRule{}, clp.redaction...), clp.namespaceRedaction...)
+	for _, watchLog := range clp.watchLogs {
+		for i, s := range watchLog.Spec.Sources {
+			if s.Name != source || (s.Container != "" && s.Container != containerName) {
+				continue
+			}
+			path := field.NewPath(watchLog.Name, "sources").Index(i).Child("redact")
+			if errs := crdk8sv1alpha1.ValidateRedactionRules(s.Redact, path); len(errs) > 0 {
+				return nil, errs.ToAggregate()
+			}
+			rules = append(rules, s.Redact...)
+		}
+	}
+	for i := range rules {
+		if rules[i].Field == "" {
+			rules[i].Field = redactField(format)
+		}
+	}
+	return rules, nil
+}
+
+// redactEvent applies the rules to a sample event the way the filebeat processors do,
+// the fingerprint processor hashes |field|value| rather than the value alone.
+func redactEvent(rules []crdk8sv1alpha1.RedactionRule, event map[string]interface{}) {
+	for _, rule := range rules {
+		value, ok := event[rule.Field]
+		if !ok {
+			continue
+		}
+		switch rule.Action {
+		case crdk8sv1alpha1.RedactReplace:
+			s, ok := value.(string)
+			if !ok {
+				continue
+			}
+			event[rule.Field] = regexp.MustCompile(rule.Pattern).ReplaceAllString(s, rule.Replacement)
+		case crdk8sv1alpha1.RedactDrop:
+			delete(event, rule.Field)
+		case crdk8sv1alpha1.RedactHash:
+			switch value.(type) {
+			case map[string]interface{}, []interface{}:
+				// fingerprint fails on objects and arrays
+				continue
+			}
+			event[rule.Field] = fmt.Sprintf("%x", sha256.Sum256([]byte(fmt.Sprintf("|%v|%v|", rule.Field, value))))
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"testing"
+
+	crdk8sv1alpha1 "github.com/cccfs/kube-log-helper/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRedactionRulesLevels(t *testing.T) {
+	token := crdk8sv1alpha1.RedactionRule{Name: "token", Action: "Replace", Pattern: "token=[^ ]+", Replacement: "token=***"}
+	clp := &ContainerLogOptions{
+		redaction:          []crdk8sv1alpha1.RedactionRule{token},
+		namespaceRedaction: []crdk8sv1alpha1.RedactionRule{token},
+		watchLogs: []crdk8sv1alpha1.WatchLog{{
+			ObjectMeta: metav1.ObjectMeta{Name: "demo"},
+			Spec: crdk8sv1alpha1.WatchLogSpec{Sources: []crdk8sv1alpha1.LogSource{
+				{Name: "audit", Redact: []crdk8sv1alpha1.RedactionRule{token}},
+				{Name: "access", Redact: []crdk8sv1alpha1.RedactionRule{token, token}},
+			}},
+		}},
+	}
+	// the same name at the cluster, namespace and WatchLog levels
+	rules, err := clp.redactionRules("app", "audit", "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 3 {
+		t.Fatalf("got %d rules, want 3", len(rules))
+	}
+	for _, rule := range rules {
+		if rule.Field != "json.log" {
+			t.Errorf("json source rule applies to %q, want json.log", rule.Field)
+		}
+	}
+	if _, err := clp.redactionRules("app", "access", "none"); err == nil {
+		t.Error("duplicate rule names of a WatchLog source were accepted")
+	}
+}
+
+func TestSampleEvent(t *testing.T) {
+	input := &FilebeatInputConfigOptions{
+		Format:       "json",
+		IncludeLines: []string{"^GET"},
+		Redactions: []crdk8sv1alpha1.RedactionRule{
+			{Name: "token", Field: "json.log", Action: "Replace", Pattern: "token=[^ ]+", Replacement: "token=***"},
+			{Name: "user", Field: "json.user", Action: "Hash"},
+		},
+	}
+	event, dropped := sampleEvent(input, `{"log":"GET /?token=abc","user":"alice"}`)
+	if dropped != "" {
+		t.Fatalf("dropped by %s, include_lines applies to the message key", dropped)
+	}
+	if _, ok := event["message"]; ok {
+		t.Errorf("decoded json line kept a message field: %v", event)
+	}
+	if event["json.log"] != "GET /?token=***" {
+		t.Errorf("json.log = %v", event["json.log"])
+	}
+	// the fingerprint processor hashes |field|value|
+	if want := fmt.Sprintf("%x", sha256.Sum256([]byte("|json.user|alice|"))); event["json.user"] != want {
+		t.Errorf("json.user = %v, want %s", event["json.user"], want)
+	}
+
+	if _, dropped := sampleEvent(input, `{"log":"POST /","user":"alice"}`); dropped != "include_lines" {
+		t.Errorf("dropped by %q, want include_lines", dropped)
+	}
+	// lines that are not json are shipped as message
+	input.IncludeLines = nil
+	if event, _ := sampleEvent(input, "not json"); event["message"] != "not json" {
+		t.Errorf("message = %v", event["message"])
+	}
+}
@@ -0,0 +1,236 @@
+package controllers
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// registryEntry is the state filebeat keeps for one harvested file.
+type registryEntry struct {
+	Key         string `json:"_key"`
+	Source      string `json:"source"`
+	Offset      int64  `json:"offset"`
+	FileStateOS struct {
+		Inode  uint64 `json:"inode"`
+		Device uint64 `json:"device"`
+	} `json:"FileStateOS"`
+
+	// value keeps every field so the entry is written back unchanged
+	value map[string]json.RawMessage
+}
+
+// filebeatRegistry is filebeat's memlog registry: a checkpoint named by
+// active.dat holding the state at transaction txid, followed by the operations
+// appended to log.json since then.
+type filebeatRegistry struct {
+	fs      FileSystem
+	dir     string
+	txid    uint64
+	entries map[string]*registryEntry
+}
+
+type registryOp struct {
+	Op string `json:"op"`
+	ID uint64 `json:"id"`
+}
+
+type registryLogEntry struct {
+	Key   string          `json:"k"`
+	Value json.RawMessage `json:"v"`
+}
+
+// readRegistry loads the registry read-only, it is safe while filebeat runs.
+func (l FilebeatLayout) readRegistry() (*filebeatRegistry, error) {
+	dir := l.RegistryDir
+	registry := &filebeatRegistry{
+		fs:      l.FS,
+		dir:     dir,
+		entries: make(map[string]*registryEntry),
+	}
+
+	active, err := l.FS.ReadFile(filepath.Join(dir, "active.dat"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if checkpoint := strings.TrimSpace(string(active)); checkpoint != "" {
+		if !filepath.IsAbs(checkpoint) {
+			checkpoint = filepath.Join(dir, checkpoint)
+		}
+		if err := registry.readCheckpoint(checkpoint); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := registry.readLog(filepath.Join(dir, "log.json")); err != nil {
+		return nil, err
+	}
+	return registry, nil
+}
+
+func (r *filebeatRegistry) readCheckpoint(file string) error {
+	data, err := r.fs.ReadFile(file)
+	if err != nil {
+		// filebeat removes the old checkpoint right after writing a new one
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	// checkpoints are named <txid>.json
+	txid, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(file), ".json"), 10, 64)
+	if err != nil {
+		return fmt.Errorf("unexpected registry checkpoint name %s", file)
+	}
+	r.txid = txid
+
+	states := make([]map[string]json.RawMessage, 0)
+	if err := json.Unmarshal(data, &states); err != nil {
+		return fmt.Errorf("unable to parse registry checkpoint %s: %v", file, err)
+	}
+	for _, state := range states {
+		key := ""
+		if err := json.Unmarshal(state["_key"], &key); err != nil {
+			return fmt.Errorf("unable to parse registry checkpoint %s: %v", file, err)
+		}
+		delete(state, "_key")
+		if err := r.set(key, state); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *filebeatRegistry) readLog(file string) error {
+	f, err := r.fs.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		op := registryOp{}
+		if err := json.Unmarshal(scanner.Bytes(), &op); err != nil {
+			return fmt.Errorf("unable to parse registry log %s: %v", file, err)
+		}
+		// every operation is followed by its key/value line, a missing one is a
+		// write filebeat has not finished yet
+		if !scanner.Scan() {
+			break
+		}
+		entry := registryLogEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			break
+		}
+		// operations already in the checkpoint, the log was not truncated yet
+		if op.ID <= r.txid {
+			continue
+		}
+		r.txid = op.ID
+		switch op.Op {
+		case "set":
+			value := make(map[string]json.RawMessage)
+			if err := json.Unmarshal(entry.Value, &value); err != nil {
+				return fmt.Errorf("unable to parse registry entry %s: %v", entry.Key, err)
+			}
+			if err := r.set(entry.Key, value); err != nil {
+				return err
+			}
+		case "remove":
+			delete(r.entries, entry.Key)
+		}
+	}
+	return scanner.Err()
+}
+
+func (r *filebeatRegistry) set(key string, value map[string]json.RawMessage) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	state := &registryEntry{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return fmt.Errorf("unable to parse registry entry %s: %v", key, err)
+	}
+	state.Key = key
+	state.value = value
+	r.entries[key] = state
+	return nil
+}
+
+// writeCheckpoint replaces the registry by a single checkpoint of its entries,
+// filebeat must not be running as it keeps appending to log.json.
+func (r *filebeatRegistry) writeCheckpoint() error {
+	keys := make([]string, 0, len(r.entries))
+	for key := range r.entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	states := make([]map[string]json.RawMessage, 0, len(keys))
+	for _, key := range keys {
+		state := make(map[string]json.RawMessage, len(r.entries[key].value)+1)
+		for field, value := range r.entries[key].value {
+			state[field] = value
+		}
+		state["_key"], _ = json.Marshal(key)
+		states = append(states, state)
+	}
+	data, err := json.Marshal(states)
+	if err != nil {
+		return err
+	}
+
+	checkpoint := filepath.Join(r.dir, fmt.Sprintf("%d.json", r.txid))
+	if err := r.writeFileAtomic(checkpoint, data); err != nil {
+		return err
+	}
+	if err := r.writeFileAtomic(filepath.Join(r.dir, "active.dat"), []byte(checkpoint)); err != nil {
+		return err
+	}
+	if err := r.fs.Truncate(filepath.Join(r.dir, "log.json"), 0); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// drop the checkpoints replaced by the new one
+	old, err := r.fs.Glob(filepath.Join(r.dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, file := range old {
+		name := strings.TrimSuffix(filepath.Base(file), ".json")
+		if _, err := strconv.ParseUint(name, 10, 64); err != nil || file == checkpoint {
+			continue
+		}
+		if err := r.fs.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *filebeatRegistry) writeFileAtomic(file string, data []byte) error {
+	tmp := file + ".tmp"
+	if err := r.fs.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return r.fs.Rename(tmp, file)
+}
+
+// bySource indexes registry entries by the path filebeat harvested.
+func (r *filebeatRegistry) bySource() map[string][]*registryEntry {
+	sources := make(map[string][]*registryEntry, len(r.entries))
+	for _, entry := range r.entries {
+		sources[entry.Source] = append(sources[entry.Source], entry)
+	}
+	return sources
+}
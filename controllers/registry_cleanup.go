@@ -0,0 +1,212 @@
+package controllers
+
+import (
+	"context"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"os"
+	"path/filepath"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strings"
+	"time"
+)
+
+const registryCleanupInterval = time.Hour
+
+// registryOwner is the pod a harvested path belongs to, as far as the path tells.
+type registryOwner struct {
+	namespace string
+	podName   string
+	container string
+	// id of the container without its runtime, only in /var/log/containers paths
+	containerID string
+	podUID      string
+}
+
+// registryEntryOwner maps the paths written by the kubelet back to their pod:
+//
+//	/var/log/containers/<pod>_<namespace>_<container>-<container id>.log
+//	/var/log/pods/<namespace>_<pod>_<pod uid>/<container>/<n>.log
+//	/var/lib/kubelet/pods/<pod uid>/volumes/...
+func registryEntryOwner(source string) (*registryOwner, bool) {
+	switch {
+	case strings.HasPrefix(source, EnvLoggingPath+"/"):
+		name := strings.TrimSuffix(filepath.Base(source), ".log")
+		parts := strings.SplitN(name, "_", 3)
+		if len(parts) != 3 {
+			return nil, false
+		}
+		owner := &registryOwner{namespace: parts[1], podName: parts[0], container: parts[2]}
+		if i := strings.LastIndex(owner.container, "-"); i > 0 {
+			owner.container, owner.containerID = owner.container[:i], owner.container[i+1:]
+		}
+		return owner, true
+	case strings.HasPrefix(source, "/var/log/pods/"):
+		parts := strings.Split(strings.TrimPrefix(source, "/var/log/pods/"), "/")
+		pod := strings.SplitN(parts[0], "_", 3)
+		if len(parts) < 2 || len(pod) != 3 {
+			return nil, false
+		}
+		return &registryOwner{namespace: pod[0], podName: pod[1], podUID: pod[2], container: parts[1]}, true
+	case strings.HasPrefix(source, KubeletPodsDir+"/"):
+		parts := strings.SplitN(strings.TrimPrefix(source, KubeletPodsDir+"/"), "/", 2)
+		return &registryOwner{podUID: parts[0]}, true
+	}
+	return nil, false
+}
+
+// RegistryCleaner removes the registry entries of files that no longer exist and
+// whose pods or containers are gone, filebeat never does as the inputs keep
+// clean_removed off.
+// The registry is only rewritten while the supervisor holds filebeat stopped.
+type RegistryCleaner struct {
+	client   client.Client
+	filebeat FilebeatCtrlInterface
+	nodeName string
+	layout   FilebeatLayout
+}
+
+func NewRegistryCleaner(c client.Client, filebeat FilebeatCtrlInterface, layout FilebeatLayout) *RegistryCleaner {
+	return &RegistryCleaner{
+		client:   c,
+		filebeat: filebeat,
+		nodeName: os.Getenv(EnvNodeName),
+		layout:   layout,
+	}
+}
+
+// NeedLeaderElection is false, every node cleans its own registry.
+func (r *RegistryCleaner) NeedLeaderElection() bool {
+	return false
+}
+
+func (r *RegistryCleaner) Start(ctx context.Context) error {
+	// the first pass waits a full interval, the inputs of live pods are rendered by then
+	ticker := time.NewTicker(registryCleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := r.cleanup(ctx); err != nil {
+				klog.Errorf("unable to clean up filebeat registry: %v", err)
+			}
+		}
+	}
+}
+
+func (r *RegistryCleaner) cleanup(ctx context.Context) error {
+	registry, err := r.layout.readRegistry()
+	if err != nil {
+		return err
+	}
+	registryEntries.Set(float64(len(registry.entries)))
+	stale, err := r.staleEntries(ctx, registry)
+	if err != nil || len(stale) == 0 {
+		return err
+	}
+
+	return r.filebeat.WithFilebeatStopped(func() error {
+		// filebeat kept writing until it was stopped
+		registry, err := r.layout.readRegistry()
+		if err != nil {
+			return err
+		}
+		stale, err := r.staleEntries(ctx, registry)
+		if err != nil {
+			return err
+		}
+		for _, key := range stale {
+			delete(registry.entries, key)
+		}
+		if err := registry.writeCheckpoint(); err != nil {
+			return err
+		}
+		registryEntriesRemoved.Add(float64(len(stale)))
+		registryEntries.Set(float64(len(registry.entries)))
+		klog.Infof("removed %d stale entries from the filebeat registry, %d left", len(stale), len(registry.entries))
+		return nil
+	})
+}
+
+func (r *RegistryCleaner) staleEntries(ctx context.Context, registry *filebeatRegistry) ([]string, error) {
+	pods := &corev1.PodList{}
+	if err := r.client.List(ctx, pods); err != nil {
+		return nil, err
+	}
+	livePods := make(map[string]bool)
+	liveUIDs := make(map[string]bool)
+	liveContainers := make(map[string]bool)
+	for _, pod := range pods.Items {
+		if r.nodeName != "" && pod.Spec.NodeName != r.nodeName {
+			continue
+		}
+		livePods[pod.Namespace+"/"+pod.Name] = true
+		liveUIDs[string(pod.UID)] = true
+		for _, id := range podContainerIDs(&pod) {
+			liveContainers[id] = true
+		}
+	}
+	patterns := make([]string, 0)
+	for _, record := range renderedInputs.snapshot() {
+		for _, input := range record.inputs {
+			patterns = append(patterns, input.Paths()...)
+		}
+	}
+
+	stale := make([]string, 0)
+	for key, entry := range registry.entries {
+		if _, err := r.layout.FS.Stat(entry.Source); !os.IsNotExist(err) {
+			continue
+		}
+		if owner, ok := registryEntryOwner(entry.Source); ok {
+			// a restarted container or a pod recreated under the same name has a new
+			// container id, the files of the previous one are not coming back
+			if owner.containerID != "" {
+				if liveContainers[owner.containerID] {
+					continue
+				}
+			} else if livePods[owner.namespace+"/"+owner.podName] || liveUIDs[owner.podUID] {
+				continue
+			}
+		} else if matchesAny(patterns, entry.Source) {
+			// a host path shared by pods, still collected by a live one
+			continue
+		}
+		stale = append(stale, key)
+	}
+	return stale, nil
+}
+
+// podContainerIDs returns the ids, without their runtime, of the current and previous
+// containers of a pod, the kubelet keeps the logs of the previous ones.
+func podContainerIDs(pod *corev1.Pod) []string {
+	ids := make([]string, 0)
+	for _, statuses := range [][]corev1.ContainerStatus{pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses, pod.Status.EphemeralContainerStatuses} {
+		for _, status := range statuses {
+			for _, id := range []string{status.ContainerID, terminatedContainerID(status.LastTerminationState)} {
+				if i := strings.Index(id, "://"); i >= 0 {
+					ids = append(ids, id[i+3:])
+				}
+			}
+		}
+	}
+	return ids
+}
+
+func terminatedContainerID(state corev1.ContainerState) string {
+	if state.Terminated == nil {
+		return ""
+	}
+	return state.Terminated.ContainerID
+}
+
+func matchesAny(patterns []string, path string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, path); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestRegistryCleanerStaleEntries(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	pod := testPod(corev1.Container{Name: "app"})
+	layout, fakeFS, _ := fakeLayout()
+	cleaner := NewRegistryCleaner(fake.NewClientBuilder().WithScheme(scheme).WithObjects(pod).Build(), nil, layout)
+	cleaner.nodeName = "node-1"
+
+	registry := &filebeatRegistry{entries: make(map[string]*registryEntry)}
+	for key, source := range map[string]string{
+		"current":   EnvLoggingPath + "/demo-0_team-a_app-app0123456789.log",
+		"restarted": EnvLoggingPath + "/demo-0_team-a_app-0000000000.log",
+		"gone":      EnvLoggingPath + "/demo-1_team-a_app-1111111111.log",
+		"uid":       "/var/log/pods/team-a_demo-0_" + string(pod.UID) + "/app/0.log",
+		"present":   "/var/log/app.log",
+	} {
+		registry.entries[key] = &registryEntry{Key: key, Source: source}
+	}
+	if err := fakeFS.MkdirAll("/var/log", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := fakeFS.WriteFile("/var/log/app.log", nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	stale, err := cleaner.staleEntries(context.Background(), registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(stale)
+	if want := []string{"gone", "restarted"}; !reflect.DeepEqual(stale, want) {
+		t.Errorf("stale entries %v, want %v", stale, want)
+	}
+}
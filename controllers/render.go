@@ -0,0 +1,338 @@
+package controllers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	crdk8sv1alpha1 "github.com/cccfs/kube-log-helper/api/v1alpha1"
+	"io"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	dryRunPodUID      = "pod-uid"
+	dryRunContainerID = "containerd://container-id"
+)
+
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// RenderCommand implements `kube-log-helper render`: it prints the filebeat inputs
+// of the pods described by manifests without a cluster, so k8s_logs_* declarations
+// can be checked in CI. The LOGGING_* env vars apply as they do in the DaemonSet.
+func RenderCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("render", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var files, resources, samples stringList
+	fs.Var(&files, "f", "Pod or workload manifest, - reads stdin. May be repeated.")
+	fs.Var(&resources, "watchlog", "WatchLog or LogPolicy manifest applied to the pods. May be repeated.")
+	fs.Var(&samples, "sample", "Sample log line run through the filters and redaction rules of every input. May be repeated.")
+	output := fs.String("output", "filebeat", "Config to render, only filebeat is supported.")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *output != "filebeat" {
+		fmt.Fprintf(stderr, "output %q is not supported, only filebeat inputs can be rendered\n", *output)
+		return 2
+	}
+	if len(files) == 0 {
+		fmt.Fprintln(stderr, "render: -f is required")
+		fs.Usage()
+		return 2
+	}
+
+	objects := make([]runtime.Object, 0)
+	for _, file := range append(files, resources...) {
+		decoded, err := readManifests(file, stdin)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", file, err)
+			return 2
+		}
+		objects = append(objects, decoded...)
+	}
+
+	status := 0
+	pods := make([]*corev1.Pod, 0)
+	watchLogs := make([]crdk8sv1alpha1.WatchLog, 0)
+	policies := make(map[string][]crdk8sv1alpha1.LogPolicy)
+	for _, obj := range objects {
+		switch o := obj.(type) {
+		case *crdk8sv1alpha1.WatchLog:
+			watchLogs = append(watchLogs, *o)
+		case *crdk8sv1alpha1.LogPolicy:
+			if o.Namespace == "" {
+				o.Namespace = metav1.NamespaceDefault
+			}
+			policies[o.Namespace] = append(policies[o.Namespace], *o)
+		default:
+			if err := expandWorkloadShorthand(obj); err != nil {
+				fmt.Fprintf(stderr, "%s: %v\n", obj.GetObjectKind().GroupVersionKind().Kind, err)
+				status = 1
+				continue
+			}
+			pod, ok := dryRunPod(obj)
+			if !ok {
+				fmt.Fprintf(stderr, "skipping %s, it has no pod template\n", obj.GetObjectKind().GroupVersionKind().Kind)
+				continue
+			}
+			pods = append(pods, pod)
+		}
+	}
+	for i := range watchLogs {
+		if watchLogs[i].Namespace == "" {
+			watchLogs[i].Namespace = metav1.NamespaceDefault
+		}
+	}
+
+	for _, pod := range pods {
+		if !renderPod(pod, watchLogs, policies, samples, stdout, stderr) {
+			status = 1
+		}
+	}
+	return status
+}
+
+// renderPod prints the pod's inputs and its failures, it returns false when a log source is invalid.
+// The samples filtered and redacted by each input are printed as comments after the config.
+func renderPod(pod *corev1.Pod, watchLogs []crdk8sv1alpha1.WatchLog, policies map[string][]crdk8sv1alpha1.LogPolicy, samples []string, stdout, stderr io.Writer) bool {
+	name := pod.Namespace + "/" + pod.Name
+	var policy *crdk8sv1alpha1.LogPolicySpec
+	if items := policies[pod.Namespace]; len(items) > 0 {
+		sort.Slice(items, func(i, j int) bool {
+			return items[i].Name < items[j].Name
+		})
+		policy = &items[0].Spec
+	}
+	helper, err := LogHelperInit(policy)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", name, err)
+		return false
+	}
+	matched := make([]crdk8sv1alpha1.WatchLog, 0)
+	for i := range watchLogs {
+		if watchLogs[i].Namespace == pod.Namespace && watchLogSelects(&watchLogs[i], pod) {
+			matched = append(matched, watchLogs[i])
+		}
+	}
+
+	clp, err := newContainerLogOptions(helper, pod, matched, nil)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", name, err)
+		return false
+	}
+	for _, failure := range clp.failures {
+		fmt.Fprintf(stderr, "%s: %s: %s\n", name, failure.reason(), failure.message())
+	}
+	for _, decision := range clp.decisions {
+		if !decision.admitted {
+			fmt.Fprintf(stderr, "%s: %s\n", name, decision.message(helper.admission.expression))
+		}
+	}
+
+	fmt.Fprintf(stdout, "# %s\n", DefaultFilebeatLayout().inputConfigFile(pod.Namespace, pod.Name))
+	if len(clp.inputConfigList) == 0 {
+		fmt.Fprintln(stdout, "# no log source is collected")
+	} else {
+		config, err := filebeatInputConfigParse(clp)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", name, err)
+			return false
+		}
+		fmt.Fprintln(stdout, config)
+	}
+	for _, input := range clp.inputConfigList {
+		for _, sample := range samples {
+			event, dropped := sampleEvent(input, sample)
+			if dropped != "" {
+				fmt.Fprintf(stdout, "# %s: dropped by %s\n", input.ID, dropped)
+				continue
+			}
+			var encoded bytes.Buffer
+			encoder := json.NewEncoder(&encoded)
+			encoder.SetEscapeHTML(false)
+			if err := encoder.Encode(event); err != nil {
+				fmt.Fprintf(stderr, "%s: %v\n", name, err)
+				return false
+			}
+			fmt.Fprintf(stdout, "# %s: %s", input.ID, encoded.String())
+		}
+	}
+	return len(clp.failures) == 0
+}
+
+// sampleEvent runs a sample line through the filters and redaction rules of an input the way
+// filebeat does, it returns the event shipped or the filter dropping it. The line is the
+// message field, a json line is decoded under json instead and filtered on its message key.
+func sampleEvent(input *FilebeatInputConfigOptions, line string) (map[string]interface{}, string) {
+	event := map[string]interface{}{redactDefaultField: line}
+	if input.Format == "json" {
+		decoded := make(map[string]interface{})
+		if err := json.Unmarshal([]byte(line), &decoded); err == nil && len(decoded) > 0 {
+			event = make(map[string]interface{}, len(decoded))
+			for k, v := range decoded {
+				event["json."+k] = v
+			}
+			line, _ = decoded[jsonMessageKey].(string)
+		}
+	}
+	if len(input.IncludeLines) > 0 && !matchesAnyRegexp(input.IncludeLines, line) {
+		return nil, "include_lines"
+	}
+	if matchesAnyRegexp(input.ExcludeLines, line) {
+		return nil, "exclude_lines"
+	}
+	for _, rule := range input.DropEvents {
+		if value, ok := event[rule.Field]; ok && regexp.MustCompile(rule.Pattern).MatchString(fmt.Sprint(value)) {
+			return nil, "drop_event " + rule.Field
+		}
+	}
+	redactEvent(input.Redactions, event)
+	return event, ""
+}
+
+func matchesAnyRegexp(patterns []string, line string) bool {
+	for _, pattern := range patterns {
+		if regexp.MustCompile(pattern).MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+func readManifests(file string, stdin io.Reader) ([]runtime.Object, error) {
+	var r io.Reader = stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	scheme := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(crdk8sv1alpha1.AddToScheme(scheme))
+	decoder := serializer.NewCodecFactory(scheme).UniversalDeserializer()
+
+	objects := make([]runtime.Object, 0)
+	reader := yaml.NewYAMLReader(bufio.NewReader(r))
+	for {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+		obj, _, err := decoder.Decode(doc, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		if list, ok := obj.(*corev1.List); ok {
+			for _, item := range list.Items {
+				obj, _, err := decoder.Decode(item.Raw, nil, nil)
+				if err != nil {
+					return nil, err
+				}
+				objects = append(objects, obj)
+			}
+			continue
+		}
+		objects = append(objects, obj)
+	}
+}
+
+// expandWorkloadShorthand expands the logs.kube-log-helper/stdout shorthand of a
+// workload as the workload webhook does before its pods are created.
+func expandWorkloadShorthand(obj runtime.Object) error {
+	meta, template := workloadTemplate(obj)
+	if template == nil {
+		return nil
+	}
+	if meta.GetNamespace() == "" {
+		meta.SetNamespace(metav1.NamespaceDefault)
+	}
+	if _, err := expandStdoutShorthand(meta, template); err != nil {
+		return fmt.Errorf("%s/%s: %v", meta.GetNamespace(), meta.GetName(), err)
+	}
+	return nil
+}
+
+// dryRunPod returns the pod a manifest would run, as if it was scheduled and its containers
+// were started, with placeholders for the pod uid and container ids.
+func dryRunPod(obj runtime.Object) (*corev1.Pod, bool) {
+	pod := &corev1.Pod{}
+	var owner metav1.Object
+	var kind schema.GroupVersionKind
+	switch o := obj.(type) {
+	case *corev1.Pod:
+		pod = o.DeepCopy()
+	case *appsv1.Deployment:
+		owner, kind = o, appsv1.SchemeGroupVersion.WithKind("Deployment")
+		pod.ObjectMeta, pod.Spec = *o.Spec.Template.ObjectMeta.DeepCopy(), *o.Spec.Template.Spec.DeepCopy()
+	case *appsv1.StatefulSet:
+		owner, kind = o, appsv1.SchemeGroupVersion.WithKind("StatefulSet")
+		pod.ObjectMeta, pod.Spec = *o.Spec.Template.ObjectMeta.DeepCopy(), *o.Spec.Template.Spec.DeepCopy()
+	case *appsv1.DaemonSet:
+		owner, kind = o, appsv1.SchemeGroupVersion.WithKind("DaemonSet")
+		pod.ObjectMeta, pod.Spec = *o.Spec.Template.ObjectMeta.DeepCopy(), *o.Spec.Template.Spec.DeepCopy()
+	case *appsv1.ReplicaSet:
+		owner, kind = o, appsv1.SchemeGroupVersion.WithKind("ReplicaSet")
+		pod.ObjectMeta, pod.Spec = *o.Spec.Template.ObjectMeta.DeepCopy(), *o.Spec.Template.Spec.DeepCopy()
+	case *batchv1.Job:
+		owner, kind = o, batchv1.SchemeGroupVersion.WithKind("Job")
+		pod.ObjectMeta, pod.Spec = *o.Spec.Template.ObjectMeta.DeepCopy(), *o.Spec.Template.Spec.DeepCopy()
+	case *batchv1.CronJob:
+		owner, kind = o, batchv1.SchemeGroupVersion.WithKind("CronJob")
+		pod.ObjectMeta, pod.Spec = *o.Spec.JobTemplate.Spec.Template.ObjectMeta.DeepCopy(), *o.Spec.JobTemplate.Spec.Template.Spec.DeepCopy()
+	default:
+		return nil, false
+	}
+	if owner != nil {
+		pod.Name = owner.GetName()
+		pod.Namespace = owner.GetNamespace()
+		pod.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(owner, kind)}
+	}
+	if pod.Namespace == "" {
+		pod.Namespace = metav1.NamespaceDefault
+	}
+	if pod.UID == "" {
+		pod.UID = dryRunPodUID
+	}
+	pod.Status.Phase = corev1.PodRunning
+	pod.Status.ContainerStatuses = make([]corev1.ContainerStatus, 0, len(pod.Spec.Containers))
+	for _, container := range pod.Spec.Containers {
+		pod.Status.ContainerStatuses = append(pod.Status.ContainerStatuses, corev1.ContainerStatus{
+			Name:        container.Name,
+			ContainerID: dryRunContainerID,
+		})
+	}
+	return pod, true
+}
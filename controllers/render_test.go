@@ -0,0 +1,66 @@
+package controllers
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const shorthandManifest = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: team-a
+  annotations:
+    logs.kube-log-helper/stdout: json
+spec:
+  selector:
+    matchLabels:
+      app: web
+  template:
+    metadata:
+      labels:
+        app: web
+    spec:
+      containers:
+      - name: app
+        image: nginx
+`
+
+func TestRenderCommand(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		status   int
+		want     []string
+	}{
+		{
+			name:     "workload shorthand",
+			manifest: shorthandManifest,
+			want: []string{
+				"- type: container",
+				`id: "team-a/web/app/app"`,
+				`"index": "team-a-web"`,
+			},
+		},
+		{
+			name:     "invalid shorthand",
+			manifest: strings.Replace(shorthandManifest, "stdout: json", "stdout: yaml", 1),
+			status:   1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+			status := RenderCommand([]string{"-f", "-"}, strings.NewReader(test.manifest), stdout, stderr)
+			if status != test.status {
+				t.Fatalf("status %d, want %d: %s", status, test.status, stderr)
+			}
+			for _, want := range test.want {
+				if !strings.Contains(stdout.String(), want) {
+					t.Errorf("%q not rendered:\n%s", want, stdout)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,251 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	crdk8sv1alpha1 "github.com/cccfs/kube-log-helper/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	"os"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	shippingCheckInterval    = time.Minute
+	defaultLagThresholdBytes = 64 * 1024 * 1024
+	defaultStallMinutes      = 10
+	inputHealthReasonLagging = "Lagging"
+	inputHealthReasonStalled = "Stalled"
+)
+
+// fileProgress is the last registry offset seen for a file and since when it has not moved.
+type fileProgress struct {
+	offset int64
+	since  time.Time
+}
+
+type inputHealthState struct {
+	record *inputRecord
+	// WatchLog declaring the input, empty when the pod declares it
+	watchLog string
+	labels   []string
+	health   crdk8sv1alpha1.InputHealth
+}
+
+// ShippingMonitor compares the files tracked by the rendered inputs with the
+// offsets in filebeat's registry, and reports inputs lagging behind or whose
+// harvester made no progress through metrics, pod events and the node status
+// reported for the WatchLogs selecting them.
+type ShippingMonitor struct {
+	client       client.Client
+	recorder     record.EventRecorder
+	nodeName     string
+	layout       FilebeatLayout
+	lagThreshold int64
+	stallAfter   time.Duration
+
+	files  map[string]*fileProgress
+	inputs map[string]*inputHealthState
+}
+
+func NewShippingMonitor(c client.Client, recorder record.EventRecorder, layout FilebeatLayout) (*ShippingMonitor, error) {
+	m := &ShippingMonitor{
+		client:       c,
+		recorder:     recorder,
+		nodeName:     os.Getenv(EnvNodeName),
+		layout:       layout,
+		lagThreshold: defaultLagThresholdBytes,
+		stallAfter:   defaultStallMinutes * time.Minute,
+		files:        make(map[string]*fileProgress),
+		inputs:       make(map[string]*inputHealthState),
+	}
+	if v := os.Getenv(EnvLoggingLagThresholdBytes); v != "" {
+		threshold, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || threshold <= 0 {
+			return nil, fmt.Errorf("invalid %s: %q", EnvLoggingLagThresholdBytes, v)
+		}
+		m.lagThreshold = threshold
+	}
+	if v := os.Getenv(EnvLoggingStallMinutes); v != "" {
+		minutes, err := strconv.Atoi(v)
+		if err != nil || minutes <= 0 {
+			return nil, fmt.Errorf("invalid %s: %q", EnvLoggingStallMinutes, v)
+		}
+		m.stallAfter = time.Duration(minutes) * time.Minute
+	}
+	return m, nil
+}
+
+// NeedLeaderElection is false, every node checks its own filebeat.
+func (m *ShippingMonitor) NeedLeaderElection() bool {
+	return false
+}
+
+func (m *ShippingMonitor) Start(ctx context.Context) error {
+	ticker := time.NewTicker(shippingCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			m.check(ctx)
+		}
+	}
+}
+
+func (m *ShippingMonitor) check(ctx context.Context) {
+	registry, err := m.layout.readRegistry()
+	if err != nil {
+		// keep the previous state, a registry being rewritten is not a stall
+		klog.Warningf("unable to read filebeat registry: %v", err)
+		return
+	}
+	sources := registry.bySource()
+	now := time.Now()
+
+	files := make(map[string]*fileProgress)
+	inputs := make(map[string]*inputHealthState)
+	for _, record := range renderedInputs.snapshot() {
+		for _, input := range record.inputs {
+			labels, ok := parseInputID(input.ID)
+			if !ok {
+				continue
+			}
+			state := &inputHealthState{
+				record:   record,
+				watchLog: input.watchLog,
+				labels:   labels,
+				health: crdk8sv1alpha1.InputHealth{
+					Node:      m.nodeName,
+					Pod:       record.podName,
+					Container: labels[2],
+					Source:    labels[3],
+				},
+			}
+			stalled := false
+			for _, path := range input.collectedFiles(m.layout.FS) {
+				info, err := m.layout.FS.Stat(path)
+				if err != nil || info.IsDir() {
+					continue
+				}
+				offset := registryOffset(sources[path], info)
+				lag := info.Size() - offset
+				if lag < 0 {
+					// truncated, filebeat starts over from the beginning
+					lag = 0
+				}
+				progress := m.files[path]
+				if progress == nil || progress.offset != offset || lag == 0 {
+					progress = &fileProgress{offset: offset, since: now}
+				}
+				files[path] = progress
+				state.health.LagBytes += lag
+				if lag > 0 && now.Sub(progress.since) > m.stallAfter {
+					stalled = true
+				}
+			}
+			switch {
+			case stalled:
+				state.health.Reason = inputHealthReasonStalled
+			case state.health.LagBytes > m.lagThreshold:
+				state.health.Reason = inputHealthReasonLagging
+			}
+			inputs[input.ID] = state
+		}
+	}
+	m.files = files
+
+	for id, state := range inputs {
+		inputLagBytes.WithLabelValues(state.labels...).Set(float64(state.health.LagBytes))
+		stalled := 0.0
+		if state.health.Reason == inputHealthReasonStalled {
+			stalled = 1
+		}
+		inputStalled.WithLabelValues(state.labels...).Set(stalled)
+
+		previous := m.inputs[id]
+		switch {
+		case state.health.Reason == "":
+			if previous != nil && previous.health.Reason != "" {
+				m.event(ctx, state.record, corev1.EventTypeNormal, EventReasonShippingRecovered,
+					fmt.Sprintf("input %s of container %s is shipping again", state.health.Source, state.health.Container))
+			}
+		case previous != nil && previous.health.Reason == state.health.Reason:
+			state.health.Since = previous.health.Since
+		default:
+			state.health.Since = metav1.NewTime(now.Truncate(time.Second))
+			m.event(ctx, state.record, corev1.EventTypeWarning, state.eventReason(), state.message(m.stallAfter))
+		}
+	}
+	for id, previous := range m.inputs {
+		if _, ok := inputs[id]; !ok {
+			inputLagBytes.DeleteLabelValues(previous.labels...)
+			inputStalled.DeleteLabelValues(previous.labels...)
+		}
+	}
+	m.inputs = inputs
+
+	unhealthy := make(map[types.NamespacedName][]crdk8sv1alpha1.InputHealth)
+	for _, state := range inputs {
+		if state.health.Reason == "" {
+			continue
+		}
+		for _, name := range sourceWatchLogs(state.watchLog, state.record.watchLogs) {
+			key := types.NamespacedName{Namespace: state.record.namespace, Name: name}
+			unhealthy[key] = append(unhealthy[key], state.health)
+		}
+	}
+	nodeResults.setUnhealthy(unhealthy)
+}
+
+// registryOffset is the offset of the registry entry matching the file's inode,
+// zero when filebeat has not started harvesting it.
+func registryOffset(entries []*registryEntry, info os.FileInfo) int64 {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	for _, entry := range entries {
+		if ok && entry.FileStateOS.Inode != 0 && entry.FileStateOS.Inode != uint64(stat.Ino) {
+			continue
+		}
+		return entry.Offset
+	}
+	return 0
+}
+
+func (s *inputHealthState) eventReason() string {
+	if s.health.Reason == inputHealthReasonStalled {
+		return EventReasonShippingStalled
+	}
+	return EventReasonShippingLagging
+}
+
+func (s *inputHealthState) message(stallAfter time.Duration) string {
+	if s.health.Reason == inputHealthReasonStalled {
+		return fmt.Sprintf("input %s of container %s made no progress for %s, %d bytes not shipped",
+			s.health.Source, s.health.Container, stallAfter, s.health.LagBytes)
+	}
+	return fmt.Sprintf("input %s of container %s has %d bytes not shipped",
+		s.health.Source, s.health.Container, s.health.LagBytes)
+}
+
+func (m *ShippingMonitor) event(ctx context.Context, record *inputRecord, eventtype, reason, message string) {
+	pod := &corev1.Pod{}
+	if err := m.client.Get(ctx, types.NamespacedName{Namespace: record.namespace, Name: record.podName}, pod); err != nil {
+		klog.Warningf("unable to fetch pod %s/%s: %v", record.namespace, record.podName, err)
+		return
+	}
+	events := &podEventRecorder{recorder: m.recorder, pod: pod}
+	for _, name := range record.watchLogs {
+		watchLog := crdk8sv1alpha1.WatchLog{}
+		if err := m.client.Get(ctx, types.NamespacedName{Namespace: record.namespace, Name: name}, &watchLog); err == nil {
+			events.watchLogs = append(events.watchLogs, watchLog)
+		}
+	}
+	events.event(eventtype, reason, message)
+}
@@ -0,0 +1,365 @@
+package controllers
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/klog/v2"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"strconv"
+	"strings"
+)
+
+// sidecarFilebeatSettings are the filebeat settings copied from the controller into the
+// pods it injects. Anything else in the controller env stays out of tenant namespaces.
+var sidecarFilebeatSettings = []string{
+	EnvFilebeatLogLevel,
+	EnvFilebeatMetricsEnabled,
+	EnvFilebeatFilesRotateeverybytes,
+	EnvFilebeatMaxProcs,
+	EnvFilebeatQueueDiskMaxSize,
+}
+
+const (
+	sidecarContainerName   = "kube-log-helper"
+	sidecarLogVolumePrefix = "kube-log-helper-logs-"
+	sidecarDataVolume      = "kube-log-helper-data"
+	sidecarConfigVolume    = "kube-log-helper-config"
+	sidecarPodInfoVolume   = "kube-log-helper-podinfo"
+	emptyDirVolumesDir     = "volumes/kubernetes.io~empty-dir"
+)
+
+// emptyDirHostPath is the node directory of an emptyDir volume of a pod.
+func emptyDirHostPath(podUID, volume string) string {
+	return filepath.Join(KubeletPodsDir, podUID, emptyDirVolumesDir, volume)
+}
+
+// isSidecarLogDir reports whether a node directory is on a log volume injected by the webhook.
+func isSidecarLogDir(dir string) bool {
+	return strings.Contains(dir, "/"+emptyDirVolumesDir+"/"+sidecarLogVolumePrefix)
+}
+
+// sidecarLogDir maps the node directory of an injected log volume to where the sidecar mounts it.
+func sidecarLogDir(dir string) string {
+	i := strings.Index(dir, "/"+emptyDirVolumesDir+"/")
+	return filepath.Join(SidecarLogsDir, dir[i+len(emptyDirVolumesDir)+2:])
+}
+
+// SidecarInjector is the pod mutating webhook of pods annotated with
+// logs.kube-log-helper/sidecar. It mounts an emptyDir on every directory of a
+// declared log path no volume holds, so the node agent finds the files under
+// /var/lib/kubelet/pods. The shipper mode also injects a filebeat sidecar running
+// `--mode=sidecar` which ships those files itself, for nodes without an agent.
+//
+// An emptyDir hides what the image has in the directory, declared log
+// directories are expected to be written by the container only.
+type SidecarInjector struct {
+	Client  client.Client
+	decoder *admission.Decoder
+}
+
+//+kubebuilder:webhook:path=/mutate-v1-pod,mutating=true,failurePolicy=ignore,sideEffects=None,groups="",resources=pods,verbs=create,versions=v1,name=mpod.kube-log-helper.deeproute.cn,admissionReviewVersions=v1
+
+func (i *SidecarInjector) Handle(ctx context.Context, req admission.Request) admission.Response {
+	pod := &corev1.Pod{}
+	if err := i.decoder.Decode(req, pod); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	mode := pod.Annotations[AnnotationSidecar]
+	switch mode {
+	case "":
+		return admission.Allowed("no sidecar requested")
+	case SidecarModeVolume, SidecarModeShipper:
+	default:
+		return admission.Allowed("").WithWarnings(fmt.Sprintf("%s must be %s or %s, got %q",
+			AnnotationSidecar, SidecarModeVolume, SidecarModeShipper, mode))
+	}
+	if pod.Namespace == "" {
+		pod.Namespace = req.Namespace
+	}
+
+	warnings, err := i.inject(ctx, pod, mode)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	marshaled, err := json.Marshal(pod)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshaled).WithWarnings(warnings...)
+}
+
+// InjectDecoder is called by the webhook server.
+func (i *SidecarInjector) InjectDecoder(d *admission.Decoder) error {
+	i.decoder = d
+	return nil
+}
+
+func (i *SidecarInjector) inject(ctx context.Context, pod *corev1.Pod, mode string) ([]string, error) {
+	r := &WatchLogReconciler{Client: i.Client}
+	policy, err := r.namespaceLogPolicy(ctx, pod.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	helper, err := LogHelperInit(policy)
+	if err != nil {
+		return nil, err
+	}
+	watchLogs, err := r.podWatchLogs(ctx, pod)
+	if err != nil {
+		return nil, err
+	}
+	// render every path source, including the ones a shipper sidecar takes over
+	render := func() (*ContainerLogOptions, error) {
+		p := pod.DeepCopy()
+		delete(p.Annotations, AnnotationSidecar)
+		return newContainerLogOptions(helper, p, watchLogs, nil)
+	}
+
+	clp, err := render()
+	if err != nil {
+		return nil, err
+	}
+	warnings := make([]string, 0)
+	for _, failure := range clp.failures {
+		var notFound *PathNotFoundError
+		if !errors.As(failure.err, &notFound) || !notFound.Unmounted {
+			continue
+		}
+		dir := filepath.Dir(filepath.Clean(notFound.Path))
+		if strings.ContainsAny(dir, "*?[") {
+			warnings = append(warnings, fmt.Sprintf("log source %s of container %s: %s has a pattern in its directory, no volume is mounted",
+				failure.source, failure.container, notFound.Path))
+			continue
+		}
+		mountLogDir(pod, failure.container, dir)
+	}
+	if mode != SidecarModeShipper {
+		return warnings, nil
+	}
+
+	clp, err = render()
+	if err != nil {
+		return nil, err
+	}
+	inputs := make([]*FilebeatInputConfigOptions, 0)
+	for _, input := range clp.inputConfigList {
+		if isSidecarLogDir(input.HostDir) {
+			shipped := *input
+			shipped.HostDir = sidecarLogDir(input.HostDir)
+			inputs = append(inputs, &shipped)
+		}
+	}
+	if len(inputs) == 0 {
+		return warnings, nil
+	}
+	image := os.Getenv(EnvSidecarImage)
+	if image == "" {
+		return append(warnings, fmt.Sprintf("%s is not set, no shipper sidecar is injected", EnvSidecarImage)), nil
+	}
+	for _, container := range pod.Spec.Containers {
+		if container.Name == sidecarContainerName {
+			return warnings, nil
+		}
+	}
+	encoded, err := json.Marshal(inputs)
+	if err != nil {
+		return nil, err
+	}
+	if pod.Annotations == nil {
+		pod.Annotations = make(map[string]string)
+	}
+	pod.Annotations[AnnotationSidecarInputs] = string(encoded)
+	addShipperSidecar(pod, image)
+	return warnings, nil
+}
+
+// mountLogDir mounts a new emptyDir on dir in the container, unless one already is.
+func mountLogDir(pod *corev1.Pod, containerName, dir string) {
+	logVolumes := 0
+	for _, volume := range pod.Spec.Volumes {
+		if strings.HasPrefix(volume.Name, sidecarLogVolumePrefix) {
+			logVolumes++
+		}
+	}
+	for i := range pod.Spec.Containers {
+		container := &pod.Spec.Containers[i]
+		if container.Name != containerName {
+			continue
+		}
+		for _, mount := range container.VolumeMounts {
+			if filepath.Clean(mount.MountPath) == dir {
+				return
+			}
+		}
+		name := fmt.Sprintf("%s%d", sidecarLogVolumePrefix, logVolumes)
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+			Name:         name,
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		})
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{Name: name, MountPath: dir})
+		return
+	}
+}
+
+func addShipperSidecar(pod *corev1.Pod, image string) {
+	// filebeat.yml and the inputs are written by the sidecar, the image is read only for its user
+	mounts := []corev1.VolumeMount{
+		{Name: sidecarConfigVolume, MountPath: FilebeatBase},
+		{Name: sidecarDataVolume, MountPath: "/var/lib/filebeat"},
+		{Name: sidecarPodInfoVolume, MountPath: SidecarPodInfoDir, ReadOnly: true},
+	}
+	for _, volume := range pod.Spec.Volumes {
+		if strings.HasPrefix(volume.Name, sidecarLogVolumePrefix) {
+			mounts = append(mounts, corev1.VolumeMount{
+				Name:      volume.Name,
+				MountPath: filepath.Join(SidecarLogsDir, volume.Name),
+				ReadOnly:  true,
+			})
+		}
+	}
+	pod.Spec.Volumes = append(pod.Spec.Volumes,
+		corev1.Volume{
+			Name:         sidecarConfigVolume,
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		},
+		corev1.Volume{
+			Name:         sidecarDataVolume,
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		},
+		corev1.Volume{
+			Name: sidecarPodInfoVolume,
+			VolumeSource: corev1.VolumeSource{DownwardAPI: &corev1.DownwardAPIVolumeSource{
+				Items: []corev1.DownwardAPIVolumeFile{{
+					Path:     "annotations",
+					FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.annotations"},
+				}},
+			}},
+		},
+	)
+
+	env := []corev1.EnvVar{
+		fieldEnv(EnvPodName, "metadata.name"),
+		fieldEnv(EnvPodNamespace, "metadata.namespace"),
+		fieldEnv(EnvNodeName, "spec.nodeName"),
+	}
+	// the filebeat settings of the controller, the settings ConfigMap is only watched by agents
+	for _, name := range sidecarFilebeatSettings {
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, corev1.EnvVar{Name: name, Value: value})
+		}
+	}
+
+	pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{
+		Name:         sidecarContainerName,
+		Image:        image,
+		Command:      []string{"/manager"},
+		Args:         []string{"--mode=sidecar"},
+		Env:          env,
+		VolumeMounts: mounts,
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("10m"),
+				corev1.ResourceMemory: resource.MustParse("32Mi"),
+			},
+			Limits: corev1.ResourceList{
+				corev1.ResourceMemory: resource.MustParse("128Mi"),
+			},
+		},
+	})
+}
+
+func fieldEnv(name, fieldPath string) corev1.EnvVar {
+	return corev1.EnvVar{
+		Name:      name,
+		ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: fieldPath}},
+	}
+}
+
+// RunSidecar ships the logs of the pod it is injected in: it renders the inputs the
+// webhook stored on the pod into a single pod config, runs filebeat and serves the
+// probes until ctx is done. It needs no access to the API server.
+func RunSidecar(ctx context.Context, probeAddr string, layout FilebeatLayout) error {
+	logHelper, err := startSidecar(layout, filepath.Join(SidecarPodInfoDir, "annotations"))
+	if err != nil {
+		return err
+	}
+	return NewStatusServer(probeAddr, logHelper.FilebeatCtrl()).Start(ctx)
+}
+
+// startSidecar writes the inputs of the annotations file and starts filebeat.
+func startSidecar(layout FilebeatLayout, annotations string) (*LogHelperEntry, error) {
+	inputs, err := readSidecarInputs(layout.FS, annotations)
+	if err != nil {
+		return nil, err
+	}
+	clp := &ContainerLogOptions{
+		podName:         os.Getenv(EnvPodName),
+		namespace:       os.Getenv(EnvPodNamespace),
+		nodeName:        os.Getenv(EnvNodeName),
+		inputConfigList: inputs,
+	}
+	// the pod had no name yet when it was admitted with a generateName
+	for _, input := range inputs {
+		input.ID = inputID(clp.namespace, clp.podName, input.Tags["k8s_container_name"], input.Name)
+	}
+	config, err := filebeatInputConfigParse(clp)
+	if err != nil {
+		return nil, err
+	}
+	file := layout.inputConfigFile(clp.namespace, clp.podName)
+	if _, err := layout.WriteInputConfig(file, config); err != nil {
+		return nil, err
+	}
+	renderedInputs.set(&inputRecord{
+		file:      file,
+		namespace: clp.namespace,
+		podName:   clp.podName,
+		inputs:    inputs,
+		hash:      fmt.Sprintf("%x", sha256.Sum256([]byte(config))),
+	})
+
+	klog.Infof("shipping %d log sources of pod %s/%s", len(inputs), clp.namespace, clp.podName)
+	return Run(layout, true)
+}
+
+// readSidecarInputs reads the inputs annotation from the downward API annotations file,
+// written one key="quoted value" per line.
+func readSidecarInputs(fsys FileSystem, file string) ([]*FilebeatInputConfigOptions, error) {
+	f, err := fsys.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), "=", 2)
+		if len(parts) != 2 || parts[0] != AnnotationSidecarInputs {
+			continue
+		}
+		value, err := strconv.Unquote(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s annotation: %v", AnnotationSidecarInputs, err)
+		}
+		inputs := make([]*FilebeatInputConfigOptions, 0)
+		if err := json.Unmarshal([]byte(value), &inputs); err != nil {
+			return nil, fmt.Errorf("invalid %s annotation: %v", AnnotationSidecarInputs, err)
+		}
+		return inputs, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("pod has no %s annotation", AnnotationSidecarInputs)
+}
@@ -0,0 +1,127 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	crdk8sv1alpha1 "github.com/cccfs/kube-log-helper/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// writeDownwardAnnotations writes the annotations the way the downward API volume does.
+func writeDownwardAnnotations(t *testing.T, fsys FileSystem, annotations map[string]string) string {
+	t.Helper()
+	lines := make([]string, 0, len(annotations))
+	for key, value := range annotations {
+		lines = append(lines, fmt.Sprintf("%s=%s", key, strconv.Quote(value)))
+	}
+	file := filepath.Join(SidecarPodInfoDir, "annotations")
+	if err := fsys.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := fsys.WriteFile(file, []byte(strings.Join(lines, "\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestShipperSidecar(t *testing.T) {
+	t.Setenv(EnvSidecarImage, "agent:test")
+	t.Setenv(EnvFilebeatLogLevel, "debug")
+	t.Setenv("FILEBEAT_OUTPUT_PASSWORD", "secret")
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := crdk8sv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	injector := &SidecarInjector{Client: fake.NewClientBuilder().WithScheme(scheme).Build()}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "demo-0",
+			Namespace:   "team-a",
+			Annotations: map[string]string{AnnotationSidecar: SidecarModeShipper},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{
+				Name: "app",
+				Env:  logEnv("k8s_logs_app", "/var/log/app/app.log"),
+			}},
+		},
+	}
+	if _, err := injector.inject(context.Background(), pod, SidecarModeShipper); err != nil {
+		t.Fatal(err)
+	}
+
+	var sidecar *corev1.Container
+	for i := range pod.Spec.Containers {
+		if pod.Spec.Containers[i].Name == sidecarContainerName {
+			sidecar = &pod.Spec.Containers[i]
+		}
+	}
+	if sidecar == nil {
+		t.Fatal("no shipper sidecar was injected")
+	}
+	if sidecar.Image != "agent:test" {
+		t.Errorf("sidecar image %s, want %s", sidecar.Image, "agent:test")
+	}
+	env := make(map[string]string)
+	for _, e := range sidecar.Env {
+		env[e.Name] = e.Value
+	}
+	if env[EnvFilebeatLogLevel] != "debug" {
+		t.Errorf("sidecar env misses %s", EnvFilebeatLogLevel)
+	}
+	if _, ok := env["FILEBEAT_OUTPUT_PASSWORD"]; ok {
+		t.Error("sidecar env leaks FILEBEAT_OUTPUT_PASSWORD of the controller")
+	}
+	volumes := make(map[string]bool)
+	for _, volume := range pod.Spec.Volumes {
+		volumes[volume.Name] = true
+	}
+	writable := make(map[string]bool)
+	for _, mount := range sidecar.VolumeMounts {
+		if !volumes[mount.Name] {
+			t.Errorf("sidecar mounts %s which is not a volume of the pod", mount.Name)
+		}
+		if !mount.ReadOnly {
+			writable[mount.MountPath] = true
+		}
+	}
+	// filebeat.yml, the inputs, the registry and the filebeat logs are written by the sidecar
+	for _, dir := range []string{FilebeatBase, "/var/lib/filebeat"} {
+		if !writable[dir] {
+			t.Errorf("sidecar has no writable volume on %s", dir)
+		}
+	}
+
+	layout, fakeFS, runner := fakeLayout()
+	t.Setenv(EnvPodName, pod.Name)
+	t.Setenv(EnvPodNamespace, pod.Namespace)
+	if _, err := startSidecar(layout, writeDownwardAnnotations(t, fakeFS, pod.Annotations)); err != nil {
+		t.Fatal(err)
+	}
+	file := layout.inputConfigFile(pod.Namespace, pod.Name)
+	t.Cleanup(func() {
+		renderedInputs.delete(file)
+	})
+	config, err := fakeFS.ReadFile(file)
+	if err != nil {
+		t.Fatalf("inputs were not written: %v", err)
+	}
+	if want := filepath.Join(SidecarLogsDir, sidecarLogVolumePrefix+"0", "app.log"); !strings.Contains(string(config), want) {
+		t.Errorf("inputs do not collect %s:\n%s", want, config)
+	}
+	if process := waitStarted(t, runner); !strings.HasPrefix(process.command, layout.Bin+" ") {
+		t.Errorf("started %q", process.command)
+	}
+}
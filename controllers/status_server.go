@@ -0,0 +1,206 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"k8s.io/apimachinery/pkg/types"
+	"net"
+	"net/http"
+	"path/filepath"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sort"
+	"sync"
+	"time"
+)
+
+const maxRecentFailures = 100
+
+// failureRecord is a log source that could not be rendered.
+type failureRecord struct {
+	Time      time.Time `json:"time"`
+	Namespace string    `json:"namespace"`
+	Pod       string    `json:"pod"`
+	Container string    `json:"container"`
+	Source    string    `json:"source"`
+	Prefix    string    `json:"prefix"`
+	Reason    string    `json:"reason"`
+	Message   string    `json:"message"`
+}
+
+// failureLog keeps the latest parse failures of this node, newest last.
+type failureLog struct {
+	sync.Mutex
+	records []failureRecord
+	// message of the log sources still failing, keyed by pod
+	failing map[types.NamespacedName]map[string]string
+}
+
+var recentFailures = &failureLog{failing: make(map[types.NamespacedName]map[string]string)}
+
+// set replaces the failures of a pod and returns the new ones, a failure repeated
+// by every reconcile of an unchanged pod is only logged the first time.
+func (l *failureLog) set(pod types.NamespacedName, failures []sourceFailure) []sourceFailure {
+	l.Lock()
+	defer l.Unlock()
+	previous := l.failing[pod]
+	failing := make(map[string]string, len(failures))
+	added := make([]sourceFailure, 0)
+	for _, failure := range failures {
+		key := failure.container + "/" + failure.source
+		failing[key] = failure.message()
+		if message, ok := previous[key]; ok && message == failure.message() {
+			continue
+		}
+		added = append(added, failure)
+		l.records = append(l.records, failureRecord{
+			Time:      time.Now(),
+			Namespace: pod.Namespace,
+			Pod:       pod.Name,
+			Container: failure.container,
+			Source:    failure.source,
+			Prefix:    failure.prefix,
+			Reason:    failure.reason(),
+			Message:   failure.err.Error(),
+		})
+	}
+	if len(l.records) > maxRecentFailures {
+		l.records = l.records[len(l.records)-maxRecentFailures:]
+	}
+	if len(failing) == 0 {
+		delete(l.failing, pod)
+	} else {
+		l.failing[pod] = failing
+	}
+	return added
+}
+
+func (l *failureLog) list() []failureRecord {
+	l.Lock()
+	defer l.Unlock()
+	return append([]failureRecord{}, l.records...)
+}
+
+type inputFileStatus struct {
+	File      string        `json:"file"`
+	Namespace string        `json:"namespace"`
+	Pod       string        `json:"pod"`
+	Hash      string        `json:"hash"`
+	WatchLogs []string      `json:"watchLogs,omitempty"`
+	Inputs    []inputStatus `json:"inputs"`
+}
+
+type inputStatus struct {
+	ID     string `json:"id"`
+	Stdout bool   `json:"stdout"`
+	Path   string `json:"path"`
+	Format string `json:"format,omitempty"`
+	Index  string `json:"index,omitempty"`
+}
+
+// StatusServer replaces the manager's health probe server, next to /healthz and
+// /readyz it serves what the agent renders and runs:
+//
+//	/status/inputs   rendered input files with their pod and content hash
+//	/status/filebeat filebeat pid, uptime and restarts
+//	/status/errors   latest log sources that failed to render
+//	/status/pods     log sources of every pod, ?namespace= limits them to one namespace
+type StatusServer struct {
+	addr     string
+	filebeat FilebeatCtrlInterface
+}
+
+// NewStatusServer serves the status of filebeat when it is not nil.
+func NewStatusServer(addr string, filebeat FilebeatCtrlInterface) *StatusServer {
+	return &StatusServer{
+		addr:     addr,
+		filebeat: filebeat,
+	}
+}
+
+// NeedLeaderElection is false, probes and status are served by every replica.
+func (s *StatusServer) NeedLeaderElection() bool {
+	return false
+}
+
+func (s *StatusServer) Start(ctx context.Context) error {
+	healthzHandler := &healthz.Handler{Checks: map[string]healthz.Checker{"healthz": healthz.Ping}}
+	readyzHandler := &healthz.Handler{Checks: map[string]healthz.Checker{"readyz": healthz.Ping}}
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", http.StripPrefix("/healthz", healthzHandler))
+	mux.Handle("/healthz/", http.StripPrefix("/healthz", healthzHandler))
+	mux.Handle("/readyz", http.StripPrefix("/readyz", readyzHandler))
+	mux.Handle("/readyz/", http.StripPrefix("/readyz", readyzHandler))
+	mux.HandleFunc("/status/inputs", s.inputs)
+	mux.HandleFunc("/status/filebeat", s.filebeatStatus)
+	mux.HandleFunc("/status/errors", s.errors)
+	mux.HandleFunc("/status/pods", s.pods)
+
+	listener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: mux}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+	if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+func (s *StatusServer) inputs(w http.ResponseWriter, _ *http.Request) {
+	files := make([]inputFileStatus, 0)
+	for _, record := range renderedInputs.snapshot() {
+		file := inputFileStatus{
+			File:      record.file,
+			Namespace: record.namespace,
+			Pod:       record.podName,
+			Hash:      record.hash,
+			WatchLogs: record.watchLogs,
+			Inputs:    make([]inputStatus, 0, len(record.inputs)),
+		}
+		for _, input := range record.inputs {
+			file.Inputs = append(file.Inputs, inputStatus{
+				ID:     input.ID,
+				Stdout: input.Stdout,
+				Path:   filepath.Join(input.HostDir, input.File),
+				Format: input.Format,
+				Index:  input.Index,
+			})
+		}
+		files = append(files, file)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].File < files[j].File
+	})
+	writeJSON(w, files)
+}
+
+func (s *StatusServer) filebeatStatus(w http.ResponseWriter, _ *http.Request) {
+	if s.filebeat == nil {
+		http.Error(w, "filebeat is only run by agents", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, s.filebeat.Status())
+}
+
+func (s *StatusServer) errors(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, recentFailures.list())
+}
+
+func (s *StatusServer) pods(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, describedPods.list(r.URL.Query().Get("namespace")))
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,34 @@
+package controllers
+
+import (
+	"errors"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestFailureLogSet(t *testing.T) {
+	log := &failureLog{failing: make(map[types.NamespacedName]map[string]string)}
+	pod := types.NamespacedName{Namespace: "team-a", Name: "demo-0"}
+	invalid := sourceFailure{container: "app", source: "access", err: errors.New("invalid rotation")}
+
+	steps := []struct {
+		name     string
+		failures []sourceFailure
+		added    int
+	}{
+		{name: "new failure", failures: []sourceFailure{invalid}, added: 1},
+		{name: "same failure reconciled again", failures: []sourceFailure{invalid}, added: 0},
+		{name: "other message", failures: []sourceFailure{{container: "app", source: "access", err: errors.New("invalid format")}}, added: 1},
+		{name: "fixed", added: 0},
+		{name: "broken again", failures: []sourceFailure{invalid}, added: 1},
+	}
+	for _, step := range steps {
+		if added := log.set(pod, step.failures); len(added) != step.added {
+			t.Errorf("%s: %d new failures, want %d", step.name, len(added), step.added)
+		}
+	}
+	if records := log.list(); len(records) != 3 {
+		t.Errorf("logged %d failures, want 3", len(records))
+	}
+}
@@ -1,18 +1,34 @@
 package controllers
 
 import (
+	"encoding/json"
+	crdk8sv1alpha1 "github.com/cccfs/kube-log-helper/api/v1alpha1"
 	"github.com/lithammer/dedent"
 	"text/template"
 )
 
 type FilebeatInputConfigOptions struct {
-	Stdout        bool
-	Multiline     bool
-	HostDir       string
-	File          string
-	Format        string
-	Tags          map[string]string
-	CustomConfigs map[string]string
+	ID               string
+	Name             string
+	Stdout           bool
+	Multiline        bool
+	MultilinePattern string
+	HostDir          string
+	File             string
+	Rotation         string
+	Format           string
+	Index            string
+	Tags             map[string]string
+	CustomConfigs    map[string]string
+	RateLimit        string
+	MaxBytes         int64
+	Redactions       []crdk8sv1alpha1.RedactionRule
+	IncludeLines     []string
+	ExcludeLines     []string
+	DropEvents       []crdk8sv1alpha1.DropEventRule
+
+	// WatchLog declaring the source, empty when the pod declares it
+	watchLog string
 }
 
 type FilebeatConfigOptions struct {
@@ -21,10 +37,21 @@ type FilebeatConfigOptions struct {
 	FilebeatFilesRotateeverybytes string
 	FilebeatMaxProcs              string
 	FilebeatSetupIlmEnabled       string
+	FilebeatHTTPHost              string
+	FilebeatHTTPPort              string
+	FilebeatQueueDiskMaxSize      string
+}
+
+// templateFuncs quotes values that may hold any character, a JSON string is a YAML string.
+var templateFuncs = template.FuncMap{
+	"quote": func(s string) (string, error) {
+		b, err := json.Marshal(s)
+		return string(b), err
+	},
 }
 
 var (
-	FilebeatInputConfTemplate = template.Must(template.New("FilebeatInputConf").Parse(
+	FilebeatInputConfTemplate = template.Must(template.New("FilebeatInputConf").Funcs(templateFuncs).Parse(
 		dedent.Dedent(`
 {{range .inputConfigList}}
 {{if .Stdout}}
@@ -32,13 +59,19 @@ var (
 {{ else }}
 - type: log
 {{end}}
+  id: {{ quote .ID }}
 {{if .Multiline }}
-  multiline.pattern: '^\d{4}-\d{2}-\d{2}\s\d{2}:\d{2}:\d{2}\d*'
+  multiline.pattern: {{ quote .MultilinePattern }}
   multiline.negate: true
   multiline.match: after
 {{end}}
   paths:
-      - {{ .HostDir }}/{{ .File }}
+  {{range .Paths}}
+      - {{ quote . }}
+  {{end}}
+  {{if .ExcludeFiles }}
+  exclude_files: [{{range $i, $p := .ExcludeFiles}}{{if $i}}, {{end}}{{ quote $p }}{{end}}]
+  {{end}}
   scan_frequency: 1s
   fields_under_root: true
   {{if eq .Format "json"}}
@@ -49,13 +82,61 @@ var (
   {{end}}
   fields:
       {{range $key, $value := .Tags}}
-      {{ $key }}: {{ $value }}
+      {{ quote $key }}: {{ quote $value }}
       {{end}}
       {{range $key, $value := $.container}}
-      {{ $key }}: {{ $value }}
+      {{ quote $key }}: {{ quote $value }}
       {{end}}
   {{range $key, $value := .CustomConfigs}}
-  {{ $key }}: {{ $value }}
+  {{ quote $key }}: {{ quote $value }}
+  {{end}}
+  {{if .MaxBytes }}
+  max_bytes: {{ .MaxBytes }}
+  {{end}}
+  {{if .IncludeLines }}
+  include_lines: [{{range $i, $p := .IncludeLines}}{{if $i}}, {{end}}{{ quote $p }}{{end}}]
+  {{end}}
+  {{if .ExcludeLines }}
+  exclude_lines: [{{range $i, $p := .ExcludeLines}}{{if $i}}, {{end}}{{ quote $p }}{{end}}]
+  {{end}}
+  {{if or .Index .RateLimit .Redactions .DropEvents }}
+  processors:
+  {{range .DropEvents}}
+    - drop_event:
+        when:
+          regexp:
+            {{ quote .Field }}: {{ quote .Pattern }}
+  {{end}}
+  {{if .RateLimit }}
+    - rate_limit:
+        limit: {{ quote .RateLimit }}
+  {{end}}
+  {{range .Redactions}}
+  {{if eq .Action "Replace"}}
+    - replace:
+        fields:
+          - field: {{ quote .Field }}
+            pattern: {{ quote .Pattern }}
+            replacement: {{ quote .Replacement }}
+        ignore_missing: true
+  {{else if eq .Action "Drop"}}
+    - drop_fields:
+        fields: [{{ quote .Field }}]
+        ignore_missing: true
+  {{else if eq .Action "Hash"}}
+    - fingerprint:
+        fields: [{{ quote .Field }}]
+        target_field: {{ quote .Field }}
+        method: sha256
+        ignore_missing: true
+  {{end}}
+  {{end}}
+  {{if .Index }}
+    - add_fields:
+        target: "@metadata"
+        fields:
+          op_type: create
+  {{end}}
   {{end}}
   clean_inactive: 36h
   ignore_older: 24h
@@ -63,10 +144,8 @@ var (
   close_removed: false
   clean_removed: false
   publisher_pipeline.disable_host: false
-  {{range $key, $value := .Tags}}
-  {{if eq $key "index"}}
-  {{ $key }}: "{{ $value }}-log"
-  {{end}}
+  {{if .Index }}
+  index: {{ quote .Index }}
   {{end}}
 {{end}}
 `)))
@@ -74,7 +153,7 @@ var (
 	FilebeatConfTemplate = template.Must(template.New("FilebeatConf").Parse(
 		dedent.Dedent(`
 path.config: /etc/filebeat
-path.logs: /var/log/filebeat
+path.logs: /var/lib/filebeat/logs
 path.data: /var/lib/filebeat/data
 filebeat.registry.path: ${path.data}/registry
 logging.level: {{ or .FilebeatLogLevel  "info" }}
@@ -84,6 +163,14 @@ max_procs: {{ or .FilebeatMaxProcs  "1" }}
 setup.template.name: "filebeat"  
 setup.template.pattern: "filebeat-*" 
 setup.ilm.enabled: {{ or .FilebeatSetupIlmEnabled "false" }}
+{{if .FilebeatQueueDiskMaxSize }}
+queue.disk:
+  path: ${path.data}/diskqueue
+  max_size: {{ .FilebeatQueueDiskMaxSize }}
+{{end}}
+http.enabled: true
+http.host: {{ or .FilebeatHTTPHost "localhost" }}
+http.port: {{ or .FilebeatHTTPPort "5066" }}
 filebeat.config:
   modules:
     enabled: false
@@ -2,17 +2,42 @@ package controllers
 
 import "os"
 
-func filebeatConfigParse() (string, error) {
+// filebeatSettings are the global filebeat settings of the settings ConfigMap,
+// keyed like the env vars they override, e.g. FILEBEAT_LOG_LEVEL: debug.
+type filebeatSettings map[string]string
+
+func (s filebeatSettings) get(key string) string {
+	if value, ok := s[key]; ok {
+		return value
+	}
+	return os.Getenv(key)
+}
+
+func filebeatConfigParse(settings filebeatSettings) (string, error) {
+	queueDiskMaxSize, err := diskQueueMaxSize(settings)
+	if err != nil {
+		return "", err
+	}
 	return Render(FilebeatConfTemplate, Data{
-		"FilebeatLogLevel":              os.Getenv(EnvFilebeatLogLevel),
-		"FilebeatMetricsEnabled":        os.Getenv(EnvFilebeatMetricsEnabled),
-		"FilebeatFilesRotateeverybytes": os.Getenv(EnvFilebeatFilesRotateeverybytes),
-		"FilebeatMaxProcs":              os.Getenv(EnvFilebeatMaxProcs),
-		"FilebeatSetupIlmEnabled":       os.Getenv(EnvFilebeatSetupIlmEnabled),
+		"FilebeatLogLevel":              settings.get(EnvFilebeatLogLevel),
+		"FilebeatMetricsEnabled":        settings.get(EnvFilebeatMetricsEnabled),
+		"FilebeatFilesRotateeverybytes": settings.get(EnvFilebeatFilesRotateeverybytes),
+		"FilebeatMaxProcs":              settings.get(EnvFilebeatMaxProcs),
+		"FilebeatSetupIlmEnabled":       settings.get(EnvFilebeatSetupIlmEnabled),
+		"FilebeatQueueDiskMaxSize":      queueDiskMaxSize,
+		// the stats collector is bound to the endpoint at startup, it only comes from env
+		"FilebeatHTTPHost": filebeatHTTPHost(),
+		"FilebeatHTTPPort": os.Getenv(EnvFilebeatHTTPPort),
 	})
 }
 
-func filebeatInputConfigParse() (string, error) {
-
-	return Render(FilebeatInputConfTemplate, Data{})
+func filebeatInputConfigParse(clp *ContainerLogOptions) (string, error) {
+	return Render(FilebeatInputConfTemplate, Data{
+		"inputConfigList": clp.inputConfigList,
+		"container": map[string]string{
+			"k8s_pod":           clp.podName,
+			"k8s_pod_namespace": clp.namespace,
+			"k8s_node_name":     clp.nodeName,
+		},
+	})
 }
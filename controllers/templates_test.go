@@ -0,0 +1,209 @@
+package controllers
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	crdk8sv1alpha1 "github.com/cccfs/kube-log-helper/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of testdata")
+
+// golden compares got with testdata/<name>, go test -run <test> -update rewrites it.
+func golden(t *testing.T, name, got string) {
+	t.Helper()
+	file := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(file, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("%v, run go test -run %s -update to create it", err, t.Name())
+	}
+	if got != string(want) {
+		t.Errorf("%s differs from the rendered output:\n%s", file, got)
+	}
+}
+
+func TestFilebeatConfTemplate(t *testing.T) {
+	tests := []struct {
+		golden   string
+		settings filebeatSettings
+	}{
+		{golden: "filebeat.yml.golden"},
+		{
+			golden: "filebeat_settings.yml.golden",
+			settings: filebeatSettings{
+				EnvFilebeatLogLevel:         "debug",
+				EnvFilebeatMaxProcs:         "4",
+				EnvFilebeatSetupIlmEnabled:  "true",
+				EnvFilebeatQueueDiskMaxSize: "10GB",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.golden, func(t *testing.T) {
+			config, err := filebeatConfigParse(test.settings)
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden(t, test.golden, config)
+		})
+	}
+}
+
+func testPod(containers ...corev1.Container) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo-0", Namespace: "team-a", UID: "1b4e28ba-2fa1-11d2-883f-0016d3cca427"},
+		Spec: corev1.PodSpec{
+			NodeName:   "node-1",
+			Containers: containers,
+			Volumes: []corev1.Volume{
+				{Name: "logs", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+			},
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+	for _, container := range containers {
+		pod.Status.ContainerStatuses = append(pod.Status.ContainerStatuses, corev1.ContainerStatus{
+			Name:        container.Name,
+			ContainerID: "containerd://" + container.Name + "0123456789",
+		})
+	}
+	return pod
+}
+
+func logEnv(pairs ...string) []corev1.EnvVar {
+	env := make([]corev1.EnvVar, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		env = append(env, corev1.EnvVar{Name: pairs[i], Value: pairs[i+1]})
+	}
+	return env
+}
+
+func TestFilebeatInputConfTemplate(t *testing.T) {
+	logsMount := []corev1.VolumeMount{{Name: "logs", MountPath: "/var/log/app"}}
+	tests := []struct {
+		golden    string
+		pod       *corev1.Pod
+		watchLogs []crdk8sv1alpha1.WatchLog
+	}{
+		{
+			golden: "inputs_stdout.yml.golden",
+			pod: testPod(corev1.Container{
+				Name: "app",
+				Env: logEnv(
+					"k8s_logs_app", "stdout",
+					"k8s_logs_app_format", "json",
+					"k8s_logs_app_tags", "env=test,team=a",
+					"k8s_logs_app_index", "demo",
+				),
+			}),
+		},
+		{
+			golden: "inputs_file.yml.golden",
+			pod: testPod(corev1.Container{
+				Name:         "app",
+				VolumeMounts: logsMount,
+				Env: logEnv(
+					"k8s_logs_access", "/var/log/app/access.log",
+					"k8s_logs_access_multiline", "java",
+					"k8s_logs_access_rotation", "dated",
+					"k8s_logs_access_config", "close_eof=true,rate_limit=100/s,max_bytes=65536",
+					"k8s_logs_access_include_lines", "(?:ERROR)|(?:WARN)",
+					"k8s_logs_access_drop_event", "message=healthz",
+				),
+			}),
+		},
+		{
+			golden: "inputs_watchlog.yml.golden",
+			pod: testPod(corev1.Container{
+				Name:         "app",
+				VolumeMounts: logsMount,
+				Env:          logEnv("k8s_logs_app", "stdout"),
+			}),
+			watchLogs: []crdk8sv1alpha1.WatchLog{{
+				ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "team-a"},
+				Spec: crdk8sv1alpha1.WatchLogSpec{
+					Sources: []crdk8sv1alpha1.LogSource{
+						// shadowed by the env declaration of the container
+						{Name: "app", Output: "/var/log/app/ignored.log"},
+						{
+							Name:     "audit",
+							Output:   "/var/log/app/audit.log",
+							Format:   "json",
+							Rotation: "copytruncate",
+							Tags:     map[string]string{"kind": "audit"},
+							Redact: []crdk8sv1alpha1.RedactionRule{
+								{Name: "token", Action: "Replace", Pattern: "token=[^ ]+", Replacement: "token=***"},
+								{Name: "user", Field: "json.user.email", Action: "Hash"},
+							},
+						},
+					},
+				},
+			}},
+		},
+	}
+	helper, err := LogHelperInit(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		t.Run(test.golden, func(t *testing.T) {
+			clp, err := newContainerLogOptions(helper, test.pod, test.watchLogs, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, failure := range clp.failures {
+				t.Fatalf("unexpected failure: %s", failure.message())
+			}
+			config, err := filebeatInputConfigParse(clp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden(t, test.golden, config)
+		})
+	}
+}
+
+// user values are quoted, a newline cannot add options to the input
+func TestFilebeatInputConfTemplateQuotes(t *testing.T) {
+	injected := "x\n  paths: [/var/log/containers/*.log]\n  index: other"
+	config, err := Render(FilebeatInputConfTemplate, Data{
+		"inputConfigList": []*FilebeatInputConfigOptions{{
+			ID:            "team-a/demo-0/app/app",
+			HostDir:       "/var/log",
+			File:          "app.log",
+			Index:         "team-a-app",
+			Tags:          map[string]string{"env": injected},
+			CustomConfigs: map[string]string{"close_eof": injected},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputs := []struct {
+		Paths    []string          `json:"paths"`
+		Index    string            `json:"index"`
+		Fields   map[string]string `json:"fields"`
+		CloseEOF string            `json:"close_eof"`
+	}{}
+	if err := yaml.Unmarshal([]byte(config), &inputs); err != nil || len(inputs) != 1 {
+		t.Fatalf("unable to parse the inputs: %v\n%s", err, config)
+	}
+	input := inputs[0]
+	if len(input.Paths) != 1 || input.Paths[0] != "/var/log/app.log" || input.Index != "team-a-app" {
+		t.Errorf("paths %v and index %q were changed:\n%s", input.Paths, input.Index, config)
+	}
+	if input.Fields["env"] != injected || input.CloseEOF != injected {
+		t.Errorf("values were not kept as is:\n%s", config)
+	}
+}
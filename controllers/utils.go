@@ -3,6 +3,7 @@ package controllers
 import (
 	"fmt"
 	"github.com/pkg/errors"
+	"sort"
 	"strings"
 	"text/template"
 )
@@ -40,3 +41,12 @@ func ParseBlocks(blocks string) (map[string]string, error) {
 	}
 	return blockMap, nil
 }
+
+func FormatBlocks(blockMap map[string]string) string {
+	kvArray := make([]string, 0, len(blockMap))
+	for key, value := range blockMap {
+		kvArray = append(kvArray, key+"="+value)
+	}
+	sort.Strings(kvArray)
+	return strings.Join(kvArray, ",")
+}
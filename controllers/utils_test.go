@@ -0,0 +1,48 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseBlocks(t *testing.T) {
+	tests := []struct {
+		name    string
+		blocks  string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "empty", blocks: "", want: map[string]string{}},
+		{name: "single", blocks: "cluster=test", want: map[string]string{"cluster": "test"}},
+		{name: "several", blocks: "env=test,team=a", want: map[string]string{"env": "test", "team": "a"}},
+		{name: "spaces are trimmed", blocks: " env = test , team=a ", want: map[string]string{"env": "test", "team": "a"}},
+		{name: "last value wins", blocks: "env=test,env=prod", want: map[string]string{"env": "prod"}},
+		{name: "no value", blocks: "env", wantErr: true},
+		{name: "empty key", blocks: "=test", wantErr: true},
+		{name: "empty value", blocks: "env= ", wantErr: true},
+		{name: "equal sign in the value", blocks: "pattern=a=b", wantErr: true},
+		{name: "trailing comma", blocks: "env=test,", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseBlocks(test.blocks)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ParseBlocks(%q) error = %v, want error %v", test.blocks, err, test.wantErr)
+			}
+			if !test.wantErr && !reflect.DeepEqual(got, test.want) {
+				t.Errorf("ParseBlocks(%q) = %v, want %v", test.blocks, got, test.want)
+			}
+		})
+	}
+}
+
+func TestFormatBlocks(t *testing.T) {
+	blocks := "team=a,env=test"
+	parsed, err := ParseBlocks(blocks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := FormatBlocks(parsed); got != "env=test,team=a" {
+		t.Errorf("FormatBlocks(ParseBlocks(%q)) = %q, want sorted pairs", blocks, got)
+	}
+}
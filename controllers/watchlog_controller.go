@@ -18,157 +18,627 @@ package controllers
 
 import (
 	"context"
+	"crypto/sha256"
 	"fmt"
+	crdk8sv1alpha1 "github.com/cccfs/kube-log-helper/api/v1alpha1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
 	"os"
+	"path/filepath"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+	"sort"
 	"strings"
 )
 
 // WatchLogReconciler reconciles a WatchLog object
 type WatchLogReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	// Layout is where the inputs of the pods are written
+	Layout FilebeatLayout
 }
 
 //+kubebuilder:rbac:groups=crd.k8s.deeproute.cn,resources=watchlogs,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=crd.k8s.deeproute.cn,resources=watchlogs/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=crd.k8s.deeproute.cn,resources=watchlogs/finalizers,verbs=update
+//+kubebuilder:rbac:groups=crd.k8s.deeproute.cn,resources=logpolicies,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
 
-// Reconcile is part of the main kubernetes reconciliation loop which aims to
-// move the current state of the cluster closer to the desired state.
-// TODO(user): Modify the Reconcile function to compare the state specified by
-// the WatchLog object against the actual cluster state, and then
-// perform operations to make the cluster state reflect the state specified by
-// the user.
-//
-// For more details, check Reconcile and its Result here:
-// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.11.0/pkg/reconcile
+// Reconcile renders the filebeat inputs of a pod scheduled to this node from its
+// container env and the WatchLogs selecting it, and removes them once the pod is gone.
 func (r *WatchLogReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	_ = log.FromContext(ctx)
-
-	// TODO(user): your logic here
 	watchLogInstance := &corev1.Pod{}
 	err := r.Client.Get(ctx, req.NamespacedName, watchLogInstance)
 	if err != nil {
 		if errors.IsNotFound(err) {
-			return ctrl.Result{}, nil
+			file := r.Layout.inputConfigFile(req.Namespace, req.Name)
+			renderedInputs.delete(file)
+			nodeResults.deletePod(req.NamespacedName)
+			describedPods.delete(req.NamespacedName)
+			recentFailures.set(req.NamespacedName, nil)
+			_, err := r.Layout.RemoveInputConfig(file)
+			return ctrl.Result{}, err
 		}
 		klog.Error(err, "unable to fetch pod")
 		return ctrl.Result{}, err
 	}
 
-	helper, err := LogHelperInit()
-	if err != nil {
-		return ctrl.Result{}, err
+	// only pods scheduled to this node are collected by the local filebeat
+	nodeName := os.Getenv(EnvNodeName)
+	if nodeName != "" && watchLogInstance.Spec.NodeName != nodeName {
+		return ctrl.Result{}, nil
 	}
 
 	if watchLogInstance.Status.Phase == "Pending" {
+		nodeResults.deletePod(req.NamespacedName)
 		return ctrl.Result{}, nil
 	}
 
-	clp := &ContainerLogOptions{
-		podName:           watchLogInstance.Name,
-		namespace:         watchLogInstance.Namespace,
-		containerID:       "",
-		containerName:     make([]string, 0),
-		containerLogPaths: make([]string, 0),
-		containerStatus:   watchLogInstance.Status.Phase,
+	helper, watchLogs, clp, err := r.podLogOptions(ctx, watchLogInstance)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	events := &podEventRecorder{
+		recorder:  r.Recorder,
+		pod:       watchLogInstance,
+		watchLogs: watchLogs,
+	}
+	result := newPodResult(clp)
+	// an invalid declaration will not get better by retrying, wait for the pod to change
+	for _, failure := range clp.failures {
+		klog.Errorf("%s/%s: %s", clp.namespace, clp.podName, failure.message())
+		events.event(corev1.EventTypeWarning, failure.reason(), failure.message())
+	}
+	// the counters measure failures, not how often an unchanged pod is reconciled
+	for _, failure := range recentFailures.set(req.NamespacedName, clp.failures) {
+		inputRenderFailures.WithLabelValues(failure.reason()).Inc()
+		if failure.reason() != EventReasonPathNotFound {
+			parseErrors.WithLabelValues(failure.prefix).Inc()
+		}
 	}
-	statusContainerStatuses := watchLogInstance.Status.ContainerStatuses
-	specContainers := watchLogInstance.Spec.Containers
-	clp.GetContainerLogPath(helper.indexPrefix, statusContainerStatuses, specContainers)
 
-	fmt.Println(clp)
+	file := r.Layout.inputConfigFile(clp.namespace, clp.podName)
+	changed, removed := false, false
+	if len(clp.inputConfigList) == 0 {
+		renderedInputs.delete(file)
+		if removed, err = r.Layout.RemoveInputConfig(file); err != nil {
+			return ctrl.Result{}, err
+		}
+	} else {
+		config, err := filebeatInputConfigParse(clp)
+		if err != nil {
+			inputRenderFailures.WithLabelValues("TemplateError").Inc()
+			return ctrl.Result{}, err
+		}
+		if changed, err = r.Layout.WriteInputConfig(file, config); err != nil {
+			inputRenderFailures.WithLabelValues("WriteError").Inc()
+			return ctrl.Result{}, err
+		}
+		renderedInputs.set(&inputRecord{
+			file:      file,
+			namespace: clp.namespace,
+			podName:   clp.podName,
+			inputs:    clp.inputConfigList,
+			hash:      fmt.Sprintf("%x", sha256.Sum256([]byte(config))),
+			watchLogs: clp.watchLogNames(),
+		})
+	}
+	nodeResults.setPod(req.NamespacedName, result)
+	describedPods.set(req.NamespacedName, clp.sourceStatuses(helper.admission))
+	if changed {
+		events.event(corev1.EventTypeNormal, EventReasonCollectionStarted, clp.collectionMessage())
+	}
+	if removed {
+		events.event(corev1.EventTypeNormal, EventReasonCollectionStopped, "no log source is collected anymore")
+	}
+	recordAdmission(events, helper.admission, clp.decisions, changed)
 	return ctrl.Result{}, nil
 }
 
+// recordAdmission reports rejected log sources on every pass, the event recorder
+// aggregates repeats, admitted ones only when the pod's inputs were rewritten.
+func recordAdmission(events *podEventRecorder, admission *AdmissionOptions, decisions []admissionDecision, changed bool) {
+	if admission == nil {
+		return
+	}
+	for _, decision := range decisions {
+		switch {
+		case !decision.admitted:
+			events.event(corev1.EventTypeNormal, EventReasonSourceRejected, decision.message(admission.expression))
+		case changed:
+			events.event(corev1.EventTypeNormal, EventReasonSourceAdmitted, decision.message(admission.expression))
+		}
+	}
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *WatchLogReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&corev1.Pod{}).
+		Watches(&source.Kind{Type: &crdk8sv1alpha1.LogPolicy{}}, handler.EnqueueRequestsFromMapFunc(r.podsInNamespace)).
+		Watches(&source.Kind{Type: &crdk8sv1alpha1.WatchLog{}}, handler.EnqueueRequestsFromMapFunc(r.podsInNamespace)).
 		Complete(r)
 }
 
+// podLogOptions parses the log sources of a pod with the namespace LogPolicy, the
+// WatchLogs selecting the pod and, for the admission, the labels of its node.
+func (r *WatchLogReconciler) podLogOptions(ctx context.Context, pod *corev1.Pod) (*LogHelperOptions, []crdk8sv1alpha1.WatchLog, *ContainerLogOptions, error) {
+	policy, err := r.namespaceLogPolicy(ctx, pod.Namespace)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	helper, err := LogHelperInit(policy)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	watchLogs, err := r.podWatchLogs(ctx, pod)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	var nodeLabels map[string]string
+	if helper.admission != nil && pod.Spec.NodeName != "" {
+		node := &corev1.Node{}
+		if err := r.Client.Get(ctx, types.NamespacedName{Name: pod.Spec.NodeName}, node); err != nil {
+			return nil, nil, nil, err
+		}
+		nodeLabels = node.Labels
+	}
+
+	clp, err := newContainerLogOptions(helper, pod, watchLogs, nodeLabels)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return helper, watchLogs, clp, nil
+}
+
+// namespaceLogPolicy returns the LogPolicy of a namespace, when several exist the first by name wins.
+func (r *WatchLogReconciler) namespaceLogPolicy(ctx context.Context, namespace string) (*crdk8sv1alpha1.LogPolicySpec, error) {
+	policies := &crdk8sv1alpha1.LogPolicyList{}
+	if err := r.Client.List(ctx, policies, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	if len(policies.Items) == 0 {
+		return nil, nil
+	}
+	sort.Slice(policies.Items, func(i, j int) bool {
+		return policies.Items[i].Name < policies.Items[j].Name
+	})
+	if len(policies.Items) > 1 {
+		klog.Warningf("namespace %s has %d log policies, using %s", namespace, len(policies.Items), policies.Items[0].Name)
+	}
+	return &policies.Items[0].Spec, nil
+}
+
+// podWatchLogs returns the WatchLogs whose selector matches the pod.
+func (r *WatchLogReconciler) podWatchLogs(ctx context.Context, pod *corev1.Pod) ([]crdk8sv1alpha1.WatchLog, error) {
+	watchLogs := &crdk8sv1alpha1.WatchLogList{}
+	if err := r.Client.List(ctx, watchLogs, client.InNamespace(pod.Namespace)); err != nil {
+		return nil, err
+	}
+	matched := make([]crdk8sv1alpha1.WatchLog, 0)
+	for _, watchLog := range watchLogs.Items {
+		if watchLogSelects(&watchLog, pod) {
+			matched = append(matched, watchLog)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		return matched[i].Name < matched[j].Name
+	})
+	return matched, nil
+}
+
+func watchLogSelects(watchLog *crdk8sv1alpha1.WatchLog, pod *corev1.Pod) bool {
+	if watchLog.Spec.Selector == nil {
+		return false
+	}
+	selector, err := metav1.LabelSelectorAsSelector(watchLog.Spec.Selector)
+	if err != nil {
+		klog.Warningf("watchlog %s/%s has an invalid selector: %v", watchLog.Namespace, watchLog.Name, err)
+		return false
+	}
+	return selector.Matches(labels.Set(pod.Labels))
+}
+
+// podsInNamespace enqueues every pod of the namespace, a changed selector may drop pods as well as add them.
+func (r *WatchLogReconciler) podsInNamespace(obj client.Object) []reconcile.Request {
+	pods := &corev1.PodList{}
+	if err := r.Client.List(context.Background(), pods, client.InNamespace(obj.GetNamespace())); err != nil {
+		klog.Error(err, "unable to list pods")
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(pods.Items))
+	for _, pod := range pods.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&pod)})
+	}
+	return requests
+}
+
 type LogHelperOptions struct {
 	indexPrefix []string
+	policy      *crdk8sv1alpha1.LogPolicySpec
+	collectAll  *CollectAllOptions
+	admission   *AdmissionOptions
+	limits      *LimitOptions
+	// cluster redaction rules
+	redaction []crdk8sv1alpha1.RedactionRule
+	naming    *IndexNaming
 }
 
-func LogHelperInit() (*LogHelperOptions, error) {
+func LogHelperInit(policy *crdk8sv1alpha1.LogPolicySpec) (*LogHelperOptions, error) {
 	// default log index prefix is 'k8s', define LOGGING_INDEX_PREFIX environment variables custom multi index prefix
 	prefix := []string{"k8s_logs_"}
 	envLoggingPrefix := os.Getenv(EnvLoggingPrefix)
 	if envLoggingPrefix != "" {
 		prefix = strings.Split(envLoggingPrefix, ",")
 	}
+	if policy != nil {
+		prefix = append(prefix, policy.IndexPrefix...)
+	}
+	collectAll, err := collectAllInit()
+	if err != nil {
+		return nil, err
+	}
+	admission, err := admissionInit()
+	if err != nil {
+		return nil, err
+	}
+	limits, err := limitsInit()
+	if err != nil {
+		return nil, err
+	}
+	redaction, err := redactionInit()
+	if err != nil {
+		return nil, err
+	}
+	naming, err := indexNamingInit()
+	if err != nil {
+		return nil, err
+	}
 	return &LogHelperOptions{
 		indexPrefix: prefix,
+		policy:      policy,
+		collectAll:  collectAll,
+		admission:   admission,
+		limits:      limits,
+		redaction:   redaction,
+		naming:      naming,
 	}, nil
 }
 
 type ContainerLogOptions struct {
-	podName           string
-	namespace         string
-	containerID       string
-	containerName     []string
-	containerLogPaths []string
-	containerStatus   corev1.PodPhase
+	podName            string
+	podUID             string
+	namespace          string
+	nodeName           string
+	containerID        string
+	containerName      []string
+	containerLogPaths  []string
+	containerStatus    corev1.PodPhase
+	volumes            []corev1.Volume
+	inputConfigList    []*FilebeatInputConfigOptions
+	collectAll         bool
+	workload           string
+	workloadIndex      string
+	podLabels          map[string]string
+	nodeLabels         map[string]string
+	watchLogs          []crdk8sv1alpha1.WatchLog
+	decisions          []admissionDecision
+	failures           []sourceFailure
+	sidecarShipper     bool
+	limits             *LimitOptions
+	redaction          []crdk8sv1alpha1.RedactionRule
+	namespaceRedaction []crdk8sv1alpha1.RedactionRule
+	naming             *IndexNaming
+}
+
+// newContainerLogOptions renders the inputs of every container of the pod.
+func newContainerLogOptions(helper *LogHelperOptions, pod *corev1.Pod, watchLogs []crdk8sv1alpha1.WatchLog, nodeLabels map[string]string) (*ContainerLogOptions, error) {
+	clp := &ContainerLogOptions{
+		podName:           pod.Name,
+		podUID:            string(pod.UID),
+		namespace:         pod.Namespace,
+		nodeName:          pod.Spec.NodeName,
+		containerID:       "",
+		containerName:     make([]string, 0),
+		containerLogPaths: make([]string, 0),
+		containerStatus:   pod.Status.Phase,
+		volumes:           pod.Spec.Volumes,
+		inputConfigList:   make([]*FilebeatInputConfigOptions, 0),
+		collectAll:        helper.collectAll.includePod(pod),
+		workload:          workloadName(pod),
+		workloadIndex:     workloadIndex(pod),
+		podLabels:         pod.Labels,
+		nodeLabels:        nodeLabels,
+		watchLogs:         watchLogs,
+		sidecarShipper:    pod.Annotations[AnnotationSidecar] == SidecarModeShipper,
+		limits:            helper.limits,
+		redaction:         helper.redaction,
+		naming:            helper.naming,
+	}
+	if helper.policy != nil {
+		clp.namespaceRedaction = helper.policy.Redact
+	}
+	if err := clp.GetContainerLogPath(helper, pod.Status.ContainerStatuses, pod.Spec.Containers); err != nil {
+		return nil, err
+	}
+	return clp, nil
+}
+
+func (clp *ContainerLogOptions) watchLogNames() []string {
+	names := make([]string, 0, len(clp.watchLogs))
+	for _, watchLog := range clp.watchLogs {
+		names = append(names, watchLog.Name)
+	}
+	return names
 }
 
-func (clp *ContainerLogOptions) GetContainerEnv(indexPrefix []string, envVar []corev1.EnvVar) error {
+// indexSource names the index of a rendered input and the log source writing to
+// it, reported in the node status to find colliding log sources.
+func (clp *ContainerLogOptions) indexSource(input *FilebeatInputConfigOptions) crdk8sv1alpha1.IndexSource {
+	return crdk8sv1alpha1.IndexSource{
+		Index:  input.Index,
+		Source: clp.namespace + "/" + input.Tags["index"],
+		Topic:  input.Tags["topic"],
+	}
+}
+
+func (clp *ContainerLogOptions) GetContainerEnv(helper *LogHelperOptions, container corev1.Container, logPath string) error {
 	// get all container envVar
 	root := newLogInfoNode("")
-	for _, env := range envVar {
+	// origins records the env prefix or resource that declared each log source
+	origins := make(map[string]string)
+	for _, env := range container.Env {
 		// skip envVar that match custom prefix
-		for _, prefix := range indexPrefix {
+		for _, prefix := range helper.indexPrefix {
 			if !strings.HasPrefix(env.Name, prefix) {
 				continue
 			}
 
 			trimLogIndexPrefix := strings.TrimPrefix(env.Name, prefix)
-			if err := root.insert(strings.Split(trimLogIndexPrefix, "_"), env.Name); err != nil {
+			keys := splitLogKeys(trimLogIndexPrefix)
+			if err := root.insert(keys, env.Value); err != nil {
 				return err
 			}
+			if _, ok := origins[keys[0]]; !ok {
+				origins[keys[0]] = prefix
+			}
+		}
+	}
+	// declaredBy records the WatchLog declaring a log source, the first one by name wins
+	declaredBy := make(map[string]string)
+	for i := range clp.watchLogs {
+		root.mergeWatchLog(container.Name, &clp.watchLogs[i].Spec)
+		for name := range root.children {
+			if _, ok := origins[name]; !ok && declaredBy[name] == "" {
+				declaredBy[name] = clp.watchLogs[i].Name
+			}
+		}
+	}
+	setOrigins(root, origins, "watchlog")
+	// a namespace policy output takes precedence over the cluster wide stdout default
+	if len(root.children) == 0 && clp.collectAll && helper.collectAll.includeContainer(container.Name) &&
+		(helper.policy == nil || helper.policy.Output == "") {
+		node := newLogInfoNode("stdout")
+		if helper.policy == nil || helper.policy.Index == "" {
+			node.children["index"] = newLogInfoNode(clp.workloadIndex)
 		}
+		root.children[container.Name] = node
+	}
+	setOrigins(root, origins, "collect_all")
+	if helper.policy != nil {
+		root.mergePolicy(container.Name, helper.policy)
 	}
+	setOrigins(root, origins, "logpolicy")
 
-	for name, children := range root.children {
-		tagsMap, err := root.parseTags()
+	names := make([]string, 0, len(root.children))
+	for name := range root.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		children := root.children[name]
+		tagsMap, err := children.parseTags()
 		if err != nil {
-			return err
+			clp.failures = append(clp.failures, sourceFailure{container: container.Name, source: name, prefix: origins[name], watchLog: declaredBy[name], err: err})
+			continue
 		}
 		// e.g: k8s_logs_xxx-xxx-xxx_tags: "env=test"
-		if os.Getenv(EnvClusterEnvName) != "" {
-			clusterName := os.Getenv(EnvClusterEnvName)
-			if tagsMap["env"] != clusterName {
-				klog.Warning("cluster env with logs tag not match, skipping logs collection")
-				break
-			}
+		decision := admissionDecision{container: container.Name, source: name}
+		decision.admitted = helper.admission.admit(clp.admissionLabels(tagsMap))
+		clp.decisions = append(clp.decisions, decision)
+		if !decision.admitted {
+			klog.V(4).Infof("%s/%s: %s", clp.namespace, clp.podName, decision.message(helper.admission.expression))
+			continue
 		}
 
-		fmt.Println("children:", name, children)
+		input, err := clp.newInputConfig(name, children, tagsMap, container, logPath)
+		if err != nil {
+			clp.failures = append(clp.failures, sourceFailure{container: container.Name, source: name, prefix: origins[name], watchLog: declaredBy[name], err: err})
+			continue
+		}
+		if input != nil {
+			input.watchLog = declaredBy[name]
+			clp.inputConfigList = append(clp.inputConfigList, input)
+		}
 	}
 	return nil
 }
 
-func (clp *ContainerLogOptions) GetContainerLogPath(indexPrefix []string, status []corev1.ContainerStatus, container []corev1.Container) error {
+func setOrigins(root *LogInfoNode, origins map[string]string, origin string) {
+	for name := range root.children {
+		if _, ok := origins[name]; !ok {
+			origins[name] = origin
+		}
+	}
+}
+
+func (clp *ContainerLogOptions) newInputConfig(name string, node *LogInfoNode, tagsMap map[string]string, container corev1.Container, logPath string) (*FilebeatInputConfigOptions, error) {
+	if err := node.parseCovertIndex(name, tagsMap); err != nil {
+		return nil, err
+	}
+	format, err := node.parseLogFormat(tagsMap)
+	if err != nil {
+		return nil, err
+	}
+	customConfigs, err := node.parseCustomConfig()
+	if err != nil {
+		return nil, err
+	}
+	rateLimit, maxBytes, err := clp.limits.apply(customConfigs)
+	if err != nil {
+		return nil, err
+	}
+	redactions, err := clp.redactionRules(container.Name, name, format)
+	if err != nil {
+		return nil, err
+	}
+	filters, err := node.parseLineFilters()
+	if err != nil {
+		return nil, err
+	}
+	multilinePattern, err := node.parseMultiline()
+	if err != nil {
+		return nil, err
+	}
+	rotation, err := node.parseRotation()
+	if err != nil {
+		return nil, err
+	}
+	index, topic := clp.naming.names(map[string]string{
+		"namespace": clp.namespace,
+		"workload":  clp.workload,
+		"container": container.Name,
+		"source":    name,
+		"index":     tagsMap["index"],
+		"topic":     tagsMap["topic"],
+	})
+	if index == "" || topic == "" {
+		return nil, fmt.Errorf("%s: index %q and topic %q render an empty name", name, tagsMap["index"], tagsMap["topic"])
+	}
+	tagsMap["topic"] = topic
+	tagsMap["k8s_container_name"] = container.Name
+
+	input := &FilebeatInputConfigOptions{
+		ID:               inputID(clp.namespace, clp.podName, container.Name, name),
+		Name:             name,
+		Multiline:        multilinePattern != "",
+		MultilinePattern: multilinePattern,
+		Format:           format,
+		Index:            index,
+		Tags:             tagsMap,
+		CustomConfigs:    customConfigs,
+		RateLimit:        rateLimit,
+		MaxBytes:         maxBytes,
+		Redactions:       redactions,
+		IncludeLines:     filters.IncludeLines,
+		ExcludeLines:     filters.ExcludeLines,
+		DropEvents:       filters.DropEvents,
+	}
+
+	// prefix_logs_xxx: "stdout" or "/var/log/app/*.log"
+	output := strings.TrimSpace(node.value)
+	if output == "stdout" {
+		// the container has not been started yet, its log file is unknown
+		if logPath == "" {
+			return nil, nil
+		}
+		// kubelet rotates the container logs, the container input follows them
+		if rotation != "" {
+			return nil, fmt.Errorf("%s: rotation only applies to log files, not stdout", name)
+		}
+		input.Stdout = true
+		input.HostDir = EnvLoggingPath
+		input.File = filepath.Base(logPath)
+		return input, nil
+	}
+	if !filepath.IsAbs(output) {
+		return nil, fmt.Errorf("%s: log output %q must be stdout or an absolute path", name, output)
+	}
+	input.Rotation = rotation
+	input.HostDir, input.File, err = clp.hostLogPath(container, output)
+	if err != nil {
+		return nil, err
+	}
+	if clp.sidecarShipper && isSidecarLogDir(input.HostDir) {
+		// shipped by the sidecar injected in the pod
+		return nil, nil
+	}
+	return input, nil
+}
+
+// hostLogPath maps a log file path inside the container to the node path of the volume holding it.
+func (clp *ContainerLogOptions) hostLogPath(container corev1.Container, logPath string) (string, string, error) {
+	dir, file := filepath.Split(filepath.Clean(logPath))
+	dir = filepath.Clean(dir)
+
+	var mount *corev1.VolumeMount
+	for i, m := range container.VolumeMounts {
+		mountPath := filepath.Clean(m.MountPath)
+		if dir != mountPath && !strings.HasPrefix(dir, mountPath+"/") {
+			continue
+		}
+		if mount == nil || len(mountPath) > len(filepath.Clean(mount.MountPath)) {
+			mount = &container.VolumeMounts[i]
+		}
+	}
+	if mount == nil {
+		return "", "", &PathNotFoundError{Path: logPath, Reason: "is not on a volume of container " + container.Name, Unmounted: true}
+	}
+	rel := strings.TrimPrefix(dir, filepath.Clean(mount.MountPath))
+
+	for _, volume := range clp.volumes {
+		if volume.Name != mount.Name {
+			continue
+		}
+		switch {
+		case volume.HostPath != nil:
+			return filepath.Join(volume.HostPath.Path, mount.SubPath, rel), file, nil
+		case volume.EmptyDir != nil:
+			return filepath.Join(emptyDirHostPath(clp.podUID, volume.Name), mount.SubPath, rel), file, nil
+		default:
+			return "", "", fmt.Errorf("volume %s of container %s must be a hostPath or emptyDir", volume.Name, container.Name)
+		}
+	}
+	return "", "", &PathNotFoundError{Path: logPath, Reason: "is on volume " + mount.Name + " which is not found"}
+}
+
+func (clp *ContainerLogOptions) collectionMessage() string {
+	sources := make([]string, 0, len(clp.inputConfigList))
+	for _, input := range clp.inputConfigList {
+		sources = append(sources, fmt.Sprintf("%s/%s -> %s", input.Tags["k8s_container_name"], input.Name, input.Tags["index"]))
+	}
+	return fmt.Sprintf("collecting log sources %s", strings.Join(sources, ", "))
+}
+
+func (clp *ContainerLogOptions) GetContainerLogPath(helper *LogHelperOptions, status []corev1.ContainerStatus, container []corev1.Container) error {
 	// csi-driver-d2t4w_gds-csi_csi-driver-4ea36377d2c0dbab0b02a5ffb350b64b4297993394b00e30629c61cd659accfc.log
 	// /var/log/containers log format: [pod name]_[namespace]_[container name]-[container id]
 	for _, containerList := range container {
+		logPath := ""
 		for _, statusList := range status {
-			if statusList.Name == containerList.Name {
+			if statusList.Name == containerList.Name && statusList.ContainerID != "" {
 				clp.containerID = strings.Split(statusList.ContainerID, "//")[1]
 				clp.containerName = append(clp.containerName, statusList.Name)
 				logFormat := fmt.Sprintf("%s/%s_%s_%s-%s.log", EnvLoggingPath, clp.podName, clp.namespace, statusList.Name, clp.containerID)
 				clp.containerLogPaths = append(clp.containerLogPaths, logFormat)
+				logPath = logFormat
 			}
 		}
-		if err := clp.GetContainerEnv(indexPrefix, containerList.Env); err != nil {
+		if err := clp.GetContainerEnv(helper, containerList, logPath); err != nil {
 			return err
 		}
 	}
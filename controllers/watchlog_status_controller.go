@@ -0,0 +1,254 @@
+package controllers
+
+import (
+	"context"
+	crdk8sv1alpha1 "github.com/cccfs/kube-log-helper/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sort"
+	"time"
+)
+
+// WatchLogStatusReconciler aggregates the WatchLogNodeStatus written by the agents
+// into the status of their WatchLog, it runs once per cluster in controller mode.
+type WatchLogStatusReconciler struct {
+	client.Client
+	// IndexLifecycle manages the elasticsearch indices of the WatchLogs when set
+	IndexLifecycle *IndexLifecycleManager
+	// Topics creates the missing kafka topics of the WatchLogs when set
+	Topics *TopicProvisioner
+}
+
+//+kubebuilder:rbac:groups=crd.k8s.deeproute.cn,resources=watchlognodestatuses,verbs=get;list;watch;delete
+//+kubebuilder:rbac:groups=crd.k8s.deeproute.cn,resources=watchlognodestatuses/status,verbs=get
+
+func (r *WatchLogStatusReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	watchLog := &crdk8sv1alpha1.WatchLog{}
+	if err := r.Client.Get(ctx, req.NamespacedName, watchLog); err != nil {
+		// node statuses are garbage collected with their WatchLog
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	nodeStatuses := &crdk8sv1alpha1.WatchLogNodeStatusList{}
+	if err := r.Client.List(ctx, nodeStatuses, client.InNamespace(req.Namespace), client.MatchingLabels{LabelWatchLog: LabelValue(req.Name)}); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	status := crdk8sv1alpha1.WatchLogStatus{}
+	var indices []crdk8sv1alpha1.IndexSource
+	for i := range nodeStatuses.Items {
+		item := &nodeStatuses.Items[i]
+		stale, err := r.stale(ctx, item)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if stale {
+			// the agent of the node is gone, its pods are not collected anymore
+			klog.Infof("removing stale watchlognodestatus %s/%s", item.Namespace, item.Name)
+			if err := r.Client.Delete(ctx, item); client.IgnoreNotFound(err) != nil {
+				return ctrl.Result{}, err
+			}
+			continue
+		}
+		status.Nodes++
+		status.Matched += item.Status.Matched
+		status.Collected += item.Status.Collected
+		status.Failed += item.Status.Failed
+		status.FailedPods = append(status.FailedPods, item.Status.FailedPods...)
+		status.UnhealthyInputs = append(status.UnhealthyInputs, item.Status.UnhealthyInputs...)
+		indices = append(indices, item.Status.Indices...)
+	}
+	sortFailedPods(status.FailedPods)
+	if len(status.FailedPods) > maxFailedPods {
+		status.FailedPods = status.FailedPods[:maxFailedPods]
+	}
+	sortInputHealth(status.UnhealthyInputs)
+	if len(indices) > 0 {
+		all := &crdk8sv1alpha1.WatchLogNodeStatusList{}
+		if err := r.Client.List(ctx, all); err != nil {
+			return ctrl.Result{}, err
+		}
+		status.IndexCollisions = indexCollisions(indices, all.Items)
+		if r.IndexLifecycle != nil {
+			managed, err := r.manageIndices(ctx, indices, all.Items)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			status.ManagedIndices = managed
+		}
+		if r.Topics != nil {
+			status.ManagedTopics = r.provisionTopics(indices)
+		}
+	}
+
+	// node statuses going stale do not trigger a reconcile
+	result := ctrl.Result{RequeueAfter: nodeStatusStaleAfter}
+	if equality.Semantic.DeepEqual(status, watchLog.Status) {
+		return result, nil
+	}
+	watchLog.Status = status
+	if err := r.Client.Status().Update(ctx, watchLog); err != nil {
+		return ctrl.Result{}, err
+	}
+	return result, nil
+}
+
+// indexCollisions finds the indices of the WatchLog other log sources also write
+// to, as reported by the node statuses of every WatchLog of the cluster. A WatchLog
+// colliding with another one learns about it on its next periodic reconcile.
+func indexCollisions(indices []crdk8sv1alpha1.IndexSource, all []crdk8sv1alpha1.WatchLogNodeStatus) []crdk8sv1alpha1.IndexCollision {
+	sources := make(map[string]map[string]bool)
+	for _, index := range indices {
+		sources[index.Index] = make(map[string]bool)
+	}
+	for _, item := range all {
+		for _, index := range item.Status.Indices {
+			if sources[index.Index] != nil {
+				sources[index.Index][index.Source] = true
+			}
+		}
+	}
+	var collisions []crdk8sv1alpha1.IndexCollision
+	for index, names := range sources {
+		if len(names) < 2 {
+			continue
+		}
+		collision := crdk8sv1alpha1.IndexCollision{Index: index}
+		for name := range names {
+			collision.Sources = append(collision.Sources, name)
+		}
+		sort.Strings(collision.Sources)
+		collisions = append(collisions, collision)
+	}
+	sort.Slice(collisions, func(i, j int) bool { return collisions[i].Index < collisions[j].Index })
+	return collisions
+}
+
+// manageIndices syncs the template and lifecycle policy of the indices of the
+// WatchLog a retention is declared for. An index shared by several WatchLogs gets the
+// longest retention of them so none loses logs it wants to keep.
+func (r *WatchLogStatusReconciler) manageIndices(ctx context.Context, indices []crdk8sv1alpha1.IndexSource, all []crdk8sv1alpha1.WatchLogNodeStatus) ([]crdk8sv1alpha1.ManagedIndex, error) {
+	watchLogs := &crdk8sv1alpha1.WatchLogList{}
+	if err := r.Client.List(ctx, watchLogs); err != nil {
+		return nil, err
+	}
+	policies := &crdk8sv1alpha1.LogPolicyList{}
+	if err := r.Client.List(ctx, policies); err != nil {
+		return nil, err
+	}
+	// the first policy by name applies, as on the agents
+	sort.Slice(policies.Items, func(i, j int) bool { return policies.Items[i].Name < policies.Items[j].Name })
+	defaults := make(map[string]string)
+	for _, policy := range policies.Items {
+		if _, ok := defaults[policy.Namespace]; !ok {
+			defaults[policy.Namespace] = policy.Spec.Retention
+		}
+	}
+	retentions := make(map[types.NamespacedName]string)
+	for _, watchLog := range watchLogs.Items {
+		retention := watchLog.Spec.Retention
+		if retention == "" {
+			retention = defaults[watchLog.Namespace]
+		}
+		retentions[types.NamespacedName{Namespace: watchLog.Namespace, Name: watchLog.Name}] = retention
+	}
+
+	wanted := make(map[string]*string)
+	for _, index := range indices {
+		wanted[index.Index] = nil
+	}
+	// indices nobody declared a retention for are left to the cluster admins
+	withRetention := make(map[string]bool)
+	for _, item := range all {
+		declared := retentions[types.NamespacedName{Namespace: item.Namespace, Name: item.Spec.WatchLog}]
+		for _, index := range item.Status.Indices {
+			current, ok := wanted[index.Index]
+			if !ok {
+				continue
+			}
+			if declared != "" {
+				withRetention[index.Index] = true
+			}
+			retention := declared
+			if current != nil {
+				retention = longerRetention(*current, declared)
+			}
+			wanted[index.Index] = &retention
+		}
+	}
+
+	managed := make([]crdk8sv1alpha1.ManagedIndex, 0, len(wanted))
+	for index, retention := range wanted {
+		if !withRetention[index] {
+			continue
+		}
+		result := crdk8sv1alpha1.ManagedIndex{Index: index, Synced: true}
+		if retention != nil {
+			result.Retention = *retention
+		}
+		if err := r.IndexLifecycle.ensure(ctx, index, result.Retention); err == errConcreteIndex {
+			result.Synced = false
+			result.Unmanaged = true
+			result.Message = err.Error()
+		} else if err != nil {
+			klog.Warningf("unable to manage index %s: %v", index, err)
+			result.Synced = false
+			result.Message = err.Error()
+		}
+		managed = append(managed, result)
+	}
+	sort.Slice(managed, func(i, j int) bool { return managed[i].Index < managed[j].Index })
+	return managed, nil
+}
+
+// provisionTopics creates the topics of the WatchLog the first time they are
+// reported, the PodTopicReconciler creates the ones declared by the containers.
+func (r *WatchLogStatusReconciler) provisionTopics(indices []crdk8sv1alpha1.IndexSource) []crdk8sv1alpha1.ManagedTopic {
+	topics := make(map[string]bool)
+	for _, index := range indices {
+		if index.Topic != "" {
+			topics[index.Topic] = true
+		}
+	}
+	managed := make([]crdk8sv1alpha1.ManagedTopic, 0, len(topics))
+	for topic := range topics {
+		result := crdk8sv1alpha1.ManagedTopic{Topic: topic, Ready: true}
+		if err := r.Topics.ensure(topic); err != nil {
+			klog.Warningf("unable to provision kafka topic %s: %v", topic, err)
+			result.Ready = false
+			result.Message = err.Error()
+		}
+		managed = append(managed, result)
+	}
+	sort.Slice(managed, func(i, j int) bool { return managed[i].Topic < managed[j].Topic })
+	return managed
+}
+
+// stale is true when the node is gone or its agent has not reported for a while.
+func (r *WatchLogStatusReconciler) stale(ctx context.Context, item *crdk8sv1alpha1.WatchLogNodeStatus) (bool, error) {
+	if time.Since(item.Status.LastUpdateTime.Time) > nodeStatusStaleAfter &&
+		time.Since(item.CreationTimestamp.Time) > nodeStatusStaleAfter {
+		return true, nil
+	}
+	node := &corev1.Node{}
+	if err := r.Client.Get(ctx, types.NamespacedName{Name: item.Spec.NodeName}, node); err != nil {
+		if errors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	return false, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *WatchLogStatusReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("watchlogstatus").
+		For(&crdk8sv1alpha1.WatchLog{}).
+		Owns(&crdk8sv1alpha1.WatchLogNodeStatus{}).
+		Complete(r)
+}
@@ -0,0 +1,207 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"net/http"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"sort"
+	"strings"
+)
+
+// WorkloadDefaulter is the mutating webhook of Deployments, StatefulSets, DaemonSets
+// and Jobs expanding the logs.kube-log-helper/stdout shorthand, e.g. "json", into
+// the k8s_logs_<container> env of every container of the pod template:
+//
+//	k8s_logs_app=stdout
+//	k8s_logs_app_format=json
+//	k8s_logs_app_index=<namespace>-<workload>
+//	k8s_logs_app_tags=workload=<workload>
+//
+// Keys a container already declares are kept, so the shorthand only fills in defaults.
+// logs.kube-log-helper/containers limits the expansion to a comma separated list of containers.
+// The injected keys are listed in the logs.kube-log-helper/stdout-env template annotation,
+// so they are rewritten when the shorthand changes and removed when it is dropped.
+type WorkloadDefaulter struct {
+	decoder *admission.Decoder
+}
+
+//+kubebuilder:webhook:path=/mutate-workload-logs,mutating=true,failurePolicy=ignore,sideEffects=None,groups=apps;batch,resources=deployments;statefulsets;daemonsets;jobs,verbs=create;update,versions=v1,name=mworkload.kube-log-helper.deeproute.cn,admissionReviewVersions=v1
+
+func (d *WorkloadDefaulter) Handle(ctx context.Context, req admission.Request) admission.Response {
+	var obj runtime.Object
+	switch req.Kind.Kind {
+	case "Deployment":
+		obj = &appsv1.Deployment{}
+	case "StatefulSet":
+		obj = &appsv1.StatefulSet{}
+	case "DaemonSet":
+		obj = &appsv1.DaemonSet{}
+	case "Job":
+		obj = &batchv1.Job{}
+	default:
+		return admission.Allowed("not a workload")
+	}
+	if err := d.decoder.Decode(req, obj); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	meta, template := workloadTemplate(obj)
+	if meta.GetNamespace() == "" {
+		meta.SetNamespace(req.Namespace)
+	}
+
+	expanded, err := expandStdoutShorthand(meta, template)
+	if err != nil {
+		return admission.Denied(err.Error())
+	}
+	if !expanded {
+		return admission.Allowed("no log shorthand to expand")
+	}
+	marshaled, err := json.Marshal(obj)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshaled)
+}
+
+// InjectDecoder is called by the webhook server.
+func (d *WorkloadDefaulter) InjectDecoder(decoder *admission.Decoder) error {
+	d.decoder = decoder
+	return nil
+}
+
+func workloadTemplate(obj runtime.Object) (metav1.Object, *corev1.PodTemplateSpec) {
+	switch o := obj.(type) {
+	case *appsv1.Deployment:
+		return o, &o.Spec.Template
+	case *appsv1.StatefulSet:
+		return o, &o.Spec.Template
+	case *appsv1.DaemonSet:
+		return o, &o.Spec.Template
+	case *batchv1.Job:
+		return o, &o.Spec.Template
+	}
+	return nil, nil
+}
+
+// expandStdoutShorthand adds the env declaring the stdout of the template containers,
+// the shorthand is read from the workload annotations, then from the template ones.
+func expandStdoutShorthand(workload metav1.Object, template *corev1.PodTemplateSpec) (bool, error) {
+	injected := make(map[string]bool)
+	if value := template.Annotations[AnnotationStdoutEnv]; value != "" {
+		for _, name := range strings.Split(value, ",") {
+			injected[name] = true
+		}
+	}
+	annotations := workload.GetAnnotations()
+	format, ok := annotations[AnnotationStdout]
+	if !ok {
+		annotations = template.Annotations
+		format, ok = annotations[AnnotationStdout]
+	}
+	if !ok && len(injected) == 0 {
+		return false, nil
+	}
+	before := template.DeepCopy()
+
+	// the keys the shorthand declares per container, none once it is dropped
+	wanted := make(map[string][]corev1.EnvVar)
+	if ok {
+		format = strings.TrimSpace(format)
+		if format == "" || format == "true" {
+			format = "none"
+		}
+		// regexp needs a pattern the shorthand has no room for
+		if _, ok := converters[format]; !ok || format == "regexp" {
+			return false, fmt.Errorf("%s: unsupported log format %q", AnnotationStdout, format)
+		}
+		var containers map[string]bool
+		if value := annotations[AnnotationContainers]; value != "" {
+			containers = make(map[string]bool)
+			for _, name := range strings.Split(value, ",") {
+				containers[strings.TrimSpace(name)] = true
+			}
+		}
+
+		helper, err := LogHelperInit(nil)
+		if err != nil {
+			return false, err
+		}
+		prefix := helper.indexPrefix[0]
+		defaults := map[string]string{
+			"":       "stdout",
+			"format": format,
+			"index":  fmt.Sprintf("%s-%s", workload.GetNamespace(), workload.GetName()),
+			"tags":   FormatBlocks(map[string]string{"workload": workload.GetName()}),
+		}
+		for _, container := range template.Spec.Containers {
+			if containers != nil && !containers[container.Name] {
+				continue
+			}
+			for _, key := range []string{"", "format", "index", "tags"} {
+				name := prefix + container.Name
+				if key != "" {
+					name += "_" + key
+				}
+				wanted[container.Name] = append(wanted[container.Name], corev1.EnvVar{Name: name, Value: defaults[key]})
+			}
+		}
+	}
+
+	var names []string
+	for i := range template.Spec.Containers {
+		container := &template.Spec.Containers[i]
+		values := make(map[string]string)
+		for _, e := range wanted[container.Name] {
+			values[e.Name] = e.Value
+		}
+		declared := make(map[string]bool)
+		env := make([]corev1.EnvVar, 0, len(container.Env)+len(values))
+		for _, e := range container.Env {
+			if injected[e.Name] {
+				value, ok := values[e.Name]
+				if !ok {
+					continue
+				}
+				e.Value, e.ValueFrom = value, nil
+				names = append(names, e.Name)
+			}
+			declared[e.Name] = true
+			env = append(env, e)
+		}
+		for j, e := range wanted[container.Name] {
+			if declared[e.Name] {
+				continue
+			}
+			if j == 0 {
+				// the parser drops keys declared before their log source
+				env = append([]corev1.EnvVar{e}, env...)
+			} else {
+				env = append(env, e)
+			}
+			names = append(names, e.Name)
+		}
+		if len(env) == 0 {
+			env = nil
+		}
+		container.Env = env
+	}
+
+	if len(names) == 0 {
+		delete(template.Annotations, AnnotationStdoutEnv)
+	} else {
+		if template.Annotations == nil {
+			template.Annotations = make(map[string]string)
+		}
+		sort.Strings(names)
+		template.Annotations[AnnotationStdoutEnv] = strings.Join(names, ",")
+	}
+	return !equality.Semantic.DeepEqual(before, template), nil
+}
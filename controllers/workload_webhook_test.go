@@ -0,0 +1,121 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func shorthandDeployment(annotations map[string]string, containers ...corev1.Container) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "team-a", Annotations: annotations},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: containers}},
+		},
+	}
+}
+
+func TestExpandStdoutShorthand(t *testing.T) {
+	deployment := shorthandDeployment(
+		map[string]string{AnnotationStdout: "json", AnnotationContainers: "app"},
+		corev1.Container{Name: "app", Env: logEnv("LANG", "C", "k8s_logs_app_index", "custom")},
+		corev1.Container{Name: "proxy"},
+	)
+	template := &deployment.Spec.Template
+	expanded, err := expandStdoutShorthand(deployment, template)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !expanded {
+		t.Fatal("shorthand was not expanded")
+	}
+	want := logEnv(
+		"k8s_logs_app", "stdout",
+		"LANG", "C",
+		"k8s_logs_app_index", "custom",
+		"k8s_logs_app_format", "json",
+		"k8s_logs_app_tags", "workload=web",
+	)
+	if !reflect.DeepEqual(template.Spec.Containers[0].Env, want) {
+		t.Errorf("app env %v, want %v", template.Spec.Containers[0].Env, want)
+	}
+	if len(template.Spec.Containers[1].Env) != 0 {
+		t.Errorf("proxy is not listed in %s but got env %v", AnnotationContainers, template.Spec.Containers[1].Env)
+	}
+	if got := template.Annotations[AnnotationStdoutEnv]; got != "k8s_logs_app,k8s_logs_app_format,k8s_logs_app_tags" {
+		t.Errorf("%s = %q", AnnotationStdoutEnv, got)
+	}
+
+	// an UPDATE carries the env injected at creation
+	expanded, err = expandStdoutShorthand(deployment, template)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expanded {
+		t.Error("an unchanged shorthand rewrote the template")
+	}
+
+	deployment.Annotations[AnnotationStdout] = "nginx"
+	if _, err := expandStdoutShorthand(deployment, template); err != nil {
+		t.Fatal(err)
+	}
+	want[3].Value = "nginx"
+	if !reflect.DeepEqual(template.Spec.Containers[0].Env, want) {
+		t.Errorf("after the format changed app env %v, want %v", template.Spec.Containers[0].Env, want)
+	}
+
+	delete(deployment.Annotations, AnnotationStdout)
+	expanded, err = expandStdoutShorthand(deployment, template)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !expanded {
+		t.Fatal("dropping the shorthand left the template unchanged")
+	}
+	// keys the workload declares itself outlive the shorthand
+	if want := logEnv("LANG", "C", "k8s_logs_app_index", "custom"); !reflect.DeepEqual(template.Spec.Containers[0].Env, want) {
+		t.Errorf("after the shorthand was dropped app env %v, want %v", template.Spec.Containers[0].Env, want)
+	}
+	if _, ok := template.Annotations[AnnotationStdoutEnv]; ok {
+		t.Errorf("%s is kept after the shorthand was dropped", AnnotationStdoutEnv)
+	}
+}
+
+func TestExpandStdoutShorthandContainers(t *testing.T) {
+	deployment := shorthandDeployment(
+		map[string]string{AnnotationStdout: "true"},
+		corev1.Container{Name: "app"},
+		corev1.Container{Name: "proxy"},
+	)
+	template := &deployment.Spec.Template
+	if _, err := expandStdoutShorthand(deployment, template); err != nil {
+		t.Fatal(err)
+	}
+	if len(template.Spec.Containers[1].Env) != 4 {
+		t.Fatalf("proxy env %v", template.Spec.Containers[1].Env)
+	}
+
+	// containers taken out of the shorthand lose the keys injected for them
+	deployment.Annotations[AnnotationContainers] = "app"
+	if _, err := expandStdoutShorthand(deployment, template); err != nil {
+		t.Fatal(err)
+	}
+	if len(template.Spec.Containers[0].Env) != 4 {
+		t.Errorf("app env %v", template.Spec.Containers[0].Env)
+	}
+	if len(template.Spec.Containers[1].Env) != 0 {
+		t.Errorf("proxy env %v", template.Spec.Containers[1].Env)
+	}
+}
+
+func TestExpandStdoutShorthandInvalid(t *testing.T) {
+	for _, format := range []string{"regexp", "xml"} {
+		deployment := shorthandDeployment(map[string]string{AnnotationStdout: format}, corev1.Container{Name: "app"})
+		if _, err := expandStdoutShorthand(deployment, &deployment.Spec.Template); err == nil {
+			t.Errorf("format %s was accepted", format)
+		}
+	}
+}
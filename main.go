@@ -18,24 +18,35 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
-	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
 	crdk8sv1alpha1 "github.com/cccfs/kube-log-helper/api/v1alpha1"
 	"github.com/cccfs/kube-log-helper/controllers"
 	//+kubebuilder:scaffold:imports
 )
 
+const (
+	modeAgent      = "agent"
+	modeController = "controller"
+	modeSidecar    = "sidecar"
+)
+
 var (
 	scheme   = runtime.NewScheme()
 	setupLog = ctrl.Log.WithName("setup")
@@ -49,14 +60,28 @@ func init() {
 }
 
 func main() {
+	// kube-log-helper render -f deployment.yaml [--watchlog watchlog.yaml]
+	if len(os.Args) > 1 && os.Args[1] == "render" {
+		os.Exit(controllers.RenderCommand(os.Args[2:], os.Stdin, os.Stdout, os.Stderr))
+	}
+
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var mode string
+	var startFilebeat bool
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
-	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
+	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe and /status endpoints bind to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
-			"Enabling this will ensure there is only one active controller manager.")
+			"Enabling this will ensure there is only one active controller manager. "+
+			"Only used in controller mode, agents never elect a leader.")
+	flag.StringVar(&mode, "mode", modeAgent,
+		"agent renders the inputs of the pods of its node and runs filebeat, one per node. "+
+			"controller runs the cluster wide work once. "+
+			"sidecar ships the logs of the pod it is injected in by the sidecar webhook.")
+	flag.BoolVar(&startFilebeat, "start-filebeat", true,
+		"Start and supervise filebeat in agent mode, disable when filebeat runs in another container.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -65,12 +90,57 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	if mode != modeAgent && mode != modeController && mode != modeSidecar {
+		setupLog.Error(fmt.Errorf("unknown mode %q", mode), "expected --mode=agent, --mode=controller or --mode=sidecar")
+		os.Exit(1)
+	}
+	if mode == modeSidecar {
+		// no manager, the sidecar has no access to the API server
+		if err := controllers.RunSidecar(ctrl.SetupSignalHandler(), probeAddr, controllers.DefaultFilebeatLayout()); err != nil {
+			setupLog.Error(err, "problem running sidecar")
+			os.Exit(1)
+		}
+		return
+	}
+	nodeName := os.Getenv(controllers.EnvNodeName)
+	if mode == modeAgent && nodeName == "" {
+		setupLog.Error(fmt.Errorf("%s is not set", controllers.EnvNodeName), "agent mode needs the node it runs on")
+		os.Exit(1)
+	}
+
+	filebeatConfigMap, watchFilebeatConfig, err := controllers.FilebeatConfigMap()
+	if err != nil {
+		setupLog.Error(err, "unable to read filebeat settings configmap")
+		os.Exit(1)
+	}
+	selectors := cache.SelectorsByObject{}
+	if mode == modeAgent {
+		// an agent only sees the pods of its node
+		selectors[&corev1.Pod{}] = cache.ObjectSelector{
+			Field: fields.OneTermEqualSelector("spec.nodeName", nodeName),
+		}
+		// an agent only reads back the node statuses it writes
+		selectors[&crdk8sv1alpha1.WatchLogNodeStatus{}] = cache.ObjectSelector{
+			Label: labels.SelectorFromSet(labels.Set{controllers.LabelNode: controllers.LabelValue(nodeName)}),
+		}
+		if watchFilebeatConfig {
+			// only cache the settings ConfigMap, not every ConfigMap of the cluster
+			selectors[&corev1.ConfigMap{}] = cache.ObjectSelector{
+				Field: fields.SelectorFromSet(fields.Set{
+					"metadata.namespace": filebeatConfigMap.Namespace,
+					"metadata.name":      filebeatConfigMap.Name,
+				}),
+			}
+		}
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:                 scheme,
+		NewCache:               cache.BuilderWithOptions(cache.Options{SelectorsByObject: selectors}),
 		MetricsBindAddress:     metricsAddr,
 		Port:                   9443,
-		HealthProbeBindAddress: probeAddr,
-		LeaderElection:         enableLeaderElection,
+		HealthProbeBindAddress: "0", // probes are served by controllers.StatusServer
+		LeaderElection:         mode == modeController && enableLeaderElection,
 		LeaderElectionID:       "e0d5830c.deeproute.cn",
 	})
 	if err != nil {
@@ -78,30 +148,125 @@ func main() {
 		os.Exit(1)
 	}
 
-	if err = (&controllers.WatchLogReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
-	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "WatchLog")
-		os.Exit(1)
-	}
-	//+kubebuilder:scaffold:builder
+	var filebeat controllers.FilebeatCtrlInterface
+	if mode == modeAgent {
+		layout := controllers.DefaultFilebeatLayout()
+		if err = (&controllers.WatchLogReconciler{
+			Client:   mgr.GetClient(),
+			Scheme:   mgr.GetScheme(),
+			Recorder: mgr.GetEventRecorderFor("kube-log-helper"),
+			Layout:   layout,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "WatchLog")
+			os.Exit(1)
+		}
 
-	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
-		setupLog.Error(err, "unable to set up health check")
-		os.Exit(1)
+		monitor, err := controllers.NewShippingMonitor(mgr.GetClient(), mgr.GetEventRecorderFor("kube-log-helper"), layout)
+		if err != nil {
+			setupLog.Error(err, "unable to create shipping monitor")
+			os.Exit(1)
+		}
+		if err := mgr.Add(monitor); err != nil {
+			setupLog.Error(err, "unable to set up shipping monitor")
+			os.Exit(1)
+		}
+
+		if err := mgr.Add(controllers.NewDiskQueueMonitor(mgr.GetEventRecorderFor("kube-log-helper"), layout)); err != nil {
+			setupLog.Error(err, "unable to set up disk queue monitor")
+			os.Exit(1)
+		}
+
+		if err := mgr.Add(controllers.NewNodeStatusReporter(mgr.GetClient())); err != nil {
+			setupLog.Error(err, "unable to set up node status reporter")
+			os.Exit(1)
+		}
+
+		setupLog.Info("starting filebeat", "supervised", startFilebeat)
+		logHelper, err := controllers.Run(layout, startFilebeat)
+		if err != nil {
+			setupLog.Error(err, "unable to start filebeat")
+			os.Exit(1)
+		}
+		filebeat = logHelper.FilebeatCtrl()
+		// the registry is only rewritten while the helper holds filebeat stopped
+		if startFilebeat {
+			if err := mgr.Add(controllers.NewRegistryCleaner(mgr.GetClient(), filebeat, layout)); err != nil {
+				setupLog.Error(err, "unable to set up registry cleaner")
+				os.Exit(1)
+			}
+		}
+		switch {
+		case watchFilebeatConfig && !startFilebeat:
+			// filebeat.yml could be rewritten but the filebeat running elsewhere would not reload it
+			setupLog.Info("ignoring the filebeat settings configmap, filebeat is not supervised", "configmap", filebeatConfigMap.String())
+		case watchFilebeatConfig:
+			if err = (&controllers.FilebeatConfigReconciler{
+				Client:    mgr.GetClient(),
+				Filebeat:  filebeat,
+				Layout:    layout,
+				ConfigMap: filebeatConfigMap,
+			}).SetupWithManager(mgr); err != nil {
+				setupLog.Error(err, "unable to create controller", "controller", "FilebeatConfig")
+				os.Exit(1)
+			}
+		}
 	}
-	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
-		setupLog.Error(err, "unable to set up ready check")
-		os.Exit(1)
+	if mode == modeController {
+		statusReconciler := &controllers.WatchLogStatusReconciler{
+			Client: mgr.GetClient(),
+		}
+		if es := controllers.NewElasticsearchClient(); es != nil {
+			setupLog.Info("managing elasticsearch index templates and lifecycle policies")
+			statusReconciler.IndexLifecycle = controllers.NewIndexLifecycleManager(es)
+		}
+		topics, err := controllers.NewTopicProvisioner()
+		if err != nil {
+			setupLog.Error(err, "unable to set up kafka topic provisioning")
+			os.Exit(1)
+		}
+		if topics != nil {
+			setupLog.Info("creating missing kafka topics")
+			statusReconciler.Topics = topics
+		}
+		if err = statusReconciler.SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "WatchLogStatus")
+			os.Exit(1)
+		}
+		if topics != nil {
+			if err = (&controllers.PodTopicReconciler{
+				Client:   mgr.GetClient(),
+				Recorder: mgr.GetEventRecorderFor("kube-log-helper"),
+				Topics:   topics,
+			}).SetupWithManager(mgr); err != nil {
+				setupLog.Error(err, "unable to create controller", "controller", "PodTopic")
+				os.Exit(1)
+			}
+		}
+		if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+			mgr.GetWebhookServer().Register("/mutate-v1-pod", &webhook.Admission{
+				Handler: &controllers.SidecarInjector{Client: mgr.GetClient()},
+			})
+			mgr.GetWebhookServer().Register("/mutate-workload-logs", &webhook.Admission{
+				Handler: &controllers.WorkloadDefaulter{},
+			})
+			if err = (&crdk8sv1alpha1.WatchLog{}).SetupWebhookWithManager(mgr); err != nil {
+				setupLog.Error(err, "unable to create webhook", "webhook", "WatchLog")
+				os.Exit(1)
+			}
+			if err = (&crdk8sv1alpha1.LogPolicy{}).SetupWebhookWithManager(mgr); err != nil {
+				setupLog.Error(err, "unable to create webhook", "webhook", "LogPolicy")
+				os.Exit(1)
+			}
+		}
 	}
+	//+kubebuilder:scaffold:builder
 
-	setupLog.Info("starting filebeat")
-	if err := controllers.Run(); err != nil {
+	if err := mgr.Add(controllers.NewStatusServer(probeAddr, filebeat)); err != nil {
+		setupLog.Error(err, "unable to set up status server")
 		os.Exit(1)
 	}
 
-	setupLog.Info("starting manager")
+	setupLog.Info("starting manager", "mode", mode)
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
 		setupLog.Error(err, "problem running manager")
 		os.Exit(1)